@@ -0,0 +1,130 @@
+package caddycfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Get reads the configuration under path into out using json.Unmarshal.
+//
+// path is made of config path segments, each escaped on its own, for instance:
+//
+//	caddyCfg.Get(ctx, &servers, "apps", "http", "servers")
+//
+// stands for "/config/apps/http/servers". No path stands for the whole configuration.
+// Caddy answers with "null" for missing keys, which leaves out as is.
+func (caddyCfg *CaddyCfg) Get(ctx context.Context, out any, path ...string) error {
+	return caddyCfg.read(ctx, out, caddyCfg.pathURL("config", path...))
+}
+
+// Set replaces existing value under path with v marshalled with json.Marshal (PATCH).
+// Use json.RawMessage for v that is JSON already.
+//
+// Caddy fails if there's nothing under path yet. See Get for the format of path.
+func (caddyCfg *CaddyCfg) Set(ctx context.Context, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPatch, v, caddyCfg.pathURL("config", path...))
+}
+
+// Put creates a new value under path out of v marshalled with json.Marshal (PUT),
+// creating missing objects on the way.
+//
+// Caddy fails if there's a value under path already. If path points to an array element,
+// v gets inserted before it. See Get for the format of path.
+func (caddyCfg *CaddyCfg) Put(ctx context.Context, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPut, v, caddyCfg.pathURL("config", path...))
+}
+
+// Append adds v marshalled with json.Marshal to the end of the array under path (POST).
+// If the value under path is not an array, it gets set to v.
+//
+// See Get for the format of path.
+func (caddyCfg *CaddyCfg) Append(ctx context.Context, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPost, v, caddyCfg.pathURL("config", path...))
+}
+
+// Insert puts v marshalled with json.Marshal into the array under path at index,
+// shifting the elements at index and after it to the right.
+//
+// See Get for the format of path.
+func (caddyCfg *CaddyCfg) Insert(ctx context.Context, index int, v any, path ...string) error {
+	return caddyCfg.Put(ctx, v, append(path[:len(path):len(path)], strconv.Itoa(index))...)
+}
+
+// Delete removes the value under path. See Get for the format of path.
+func (caddyCfg *CaddyCfg) Delete(ctx context.Context, path ...string) error {
+	_, err := caddyCfg.do(ctx, http.MethodDelete, caddyCfg.pathURL("config", path...), nil)
+	return err
+}
+
+// GetByID reads the configuration section marked with "@id" id into a value of type T using json.Unmarshal.
+//
+// path optionally continues into the section, each segment escaped on its own, for instance:
+//
+//	handler, err := GetByID[map[string]any](ctx, caddyCfg, "example.com", "handle", "0")
+//
+// stands for "/id/example.com/handle/0". If the object is not found by id,
+// the returned *APIError matches errors.Is(err, ErrNotFoundID).
+func GetByID[T any](ctx context.Context, caddyCfg *CaddyCfg, id string, path ...string) (T, error) {
+	var v T
+	err := caddyCfg.read(ctx, &v, caddyCfg.pathURL("id", append([]string{id}, path...)...))
+	return v, err
+}
+
+// PatchByID replaces existing value under the configuration section marked with "@id" id
+// with v marshalled with json.Marshal. See GetByID for the format of path.
+//
+// Unlike delete-and-add, this keeps the section where it is, for instance, at the same position in routes.
+func (caddyCfg *CaddyCfg) PatchByID(ctx context.Context, id string, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPatch, v, caddyCfg.pathURL("id", append([]string{id}, path...)...))
+}
+
+// PutByID creates a new value under the configuration section marked with "@id" id out of v
+// marshalled with json.Marshal. If path points to an array element, v gets inserted before it.
+// See GetByID for the format of path.
+func (caddyCfg *CaddyCfg) PutByID(ctx context.Context, id string, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPut, v, caddyCfg.pathURL("id", append([]string{id}, path...)...))
+}
+
+// AppendByID adds v marshalled with json.Marshal to the end of the array under the configuration section
+// marked with "@id" id. See GetByID for the format of path.
+func (caddyCfg *CaddyCfg) AppendByID(ctx context.Context, id string, v any, path ...string) error {
+	return caddyCfg.write(ctx, http.MethodPost, v, caddyCfg.pathURL("id", append([]string{id}, path...)...))
+}
+
+// pathURL returns admin endpoint URL of "/<root>/<segments>", escaping each segment.
+func (caddyCfg *CaddyCfg) pathURL(root string, segments ...string) string {
+	escaped := make([]string, 0, len(segments)+1)
+	escaped = append(escaped, root)
+	for _, s := range segments {
+		escaped = append(escaped, url.PathEscape(s))
+	}
+	u := JoinURLPath(caddyCfg.configURL.String(), escaped...)
+	if len(segments) == 0 {
+		// Caddy redirects "/config" to "/config/", and redirects turn writes into GET.
+		u += "/"
+	}
+	return u
+}
+
+// read GETs url and unmarshals the response into out.
+func (caddyCfg *CaddyCfg) read(ctx context.Context, out any, url string) error {
+	b, err := caddyCfg.do(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// write sends v marshalled with json.Marshal to url with method.
+func (caddyCfg *CaddyCfg) write(ctx context.Context, method string, v any, url string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = caddyCfg.do(ctx, method, url, bytes.NewReader(b))
+	return err
+}
@@ -0,0 +1,107 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCaddyCfg_Access(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "my server"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	var listen []string
+	if err := caddyCfg.Get(ctx, &listen, "apps", "http", "servers", "my server", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(listen) != 1 || listen[0] != ":443" {
+		t.Errorf("Unexpected listen %v", listen)
+	}
+	if err := caddyCfg.Set(ctx, []string{":8443"}, "apps", "http", "servers", "my server", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Append(ctx, ":9443", "apps", "http", "servers", "my server", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Insert(ctx, 0, ":7443", "apps", "http", "servers", "my server", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Put(ctx, json.RawMessage(`{"automation":{"policies":[]}}`), "apps", "tls"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Put(ctx, json.RawMessage(`{}`), "apps", "tls"); !errors.Is(err, ErrServerError) {
+		t.Errorf("Expected existing key error, got %v", err)
+	}
+	if err := caddyCfg.Set(ctx, 1, "apps", "pki"); !errors.Is(err, ErrServerError) {
+		t.Errorf("Expected missing key error, got %v", err)
+	}
+	if err := caddyCfg.Delete(ctx, "apps", "http", "servers", "my server", "automatic_https"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	want := `{"admin":{"listen":"localhost:2019"},"apps":{"http":{"servers":{"my server":{"listen":[":7443",":8443",":9443"],"routes":[]}}},"tls":{"automation":{"policies":[]}}}}`
+	var config json.RawMessage
+	if err := caddyCfg.Get(ctx, &config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c := strings.TrimSpace(string(config)); c != want {
+		t.Errorf("Config error, want:\n%v\ngot:\n%v", want, c)
+	}
+	served := admin.served()
+	if len(served) == 0 || served[0] != "GET /config/apps/http/servers/my server/listen" || served[len(served)-1] != "GET /config/" {
+		t.Errorf("Unexpected requests %v", served)
+	}
+
+	if err := caddyCfg.Delete(ctx); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c := admin.config(); c != "null" {
+		t.Errorf("Expected no config, got %v", c)
+	}
+}
+
+func TestCaddyCfg_AccessByID(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	for i, id := range []string{"a.example.com", "b example.com"} {
+		if err := caddyCfg.AddRoute("myserver", id, ReverseProxyCaddyRouteConf(8080+i, []string{id}, "/*")); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	route, err := GetByID[RouteConfigType](ctx, caddyCfg, "b example.com")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if route.Id != "b example.com" || route.Handle[0].Upstreams[0].Dial != "localhost:8081" {
+		t.Errorf("Unexpected route %+v", route)
+	}
+	dial, err := GetByID[string](ctx, caddyCfg, "b example.com", "handle", "0", "upstreams", "0", "dial")
+	if err != nil || dial != "localhost:8081" {
+		t.Errorf("Unexpected dial %v, %v", dial, err)
+	}
+	if _, err := GetByID[json.RawMessage](ctx, caddyCfg, "c.example.com"); !errors.Is(err, ErrNotFoundID) {
+		t.Errorf("Expected not found ID error, got %v", err)
+	}
+
+	if err := caddyCfg.PatchByID(ctx, "b example.com", "localhost:9000", "handle", "0", "upstreams", "0", "dial"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.AppendByID(ctx, "b example.com", map[string]string{"dial": "localhost:9001"}, "handle", "0", "upstreams"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.PutByID(ctx, "b example.com", true, "terminal"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.DeleteByIdContext(ctx, "a.example.com", "match"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{"admin":{"listen":"localhost:2019"},"apps":{"http":{"servers":{"myserver":{"automatic_https":{"skip":[]},"listen":[":443"],"routes":[{"@id":"a.example.com","handle":[{"handler":"reverse_proxy","transport":{"protocol":"http"},"upstreams":[{"dial":"localhost:8080"}]}]},{"@id":"b example.com","handle":[{"handler":"reverse_proxy","transport":{"protocol":"http"},"upstreams":[{"dial":"localhost:9000"},{"dial":"localhost:9001"}]}],"match":[{"host":["b example.com"],"path":["/*"]}],"terminal":true}]}}}}}`
+	if c := admin.config(); c != want {
+		t.Errorf("Config error, want:\n%v\ngot:\n%v", want, c)
+	}
+}
@@ -0,0 +1,150 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// RouteBuilder composes a route out of matchers and handlers, for the cases ReverseProxyCaddyRouteConf doesn't cover:
+//
+//	route, err := caddycfg.NewRoute().
+//		Host("example.com").
+//		Path("/api/*").
+//		Method(http.MethodGet, http.MethodHead).
+//		Handle(caddycfg.ReverseProxy("localhost:8080")).
+//		Terminal().
+//		Build()
+//	if err != nil {
+//		return err
+//	}
+//	err = instance.AddRoute(serverKey, routeId, route)
+//
+// A RouteBuilder is not safe for concurrent use.
+type RouteBuilder struct {
+	group    string
+	sets     []matcherSet
+	handlers []Handler
+	terminal bool
+}
+
+// NewRoute starts building a route that matches all requests and has no handlers.
+func NewRoute() *RouteBuilder {
+	return &RouteBuilder{}
+}
+
+// Host adds MatchHost(hosts...) to the route.
+func (b *RouteBuilder) Host(hosts ...string) *RouteBuilder {
+	return b.Match(MatchHost(hosts...))
+}
+
+// Path adds MatchPath(paths...) to the route.
+func (b *RouteBuilder) Path(paths ...string) *RouteBuilder {
+	return b.Match(MatchPath(paths...))
+}
+
+// Method adds MatchMethod(methods...) to the route.
+func (b *RouteBuilder) Method(methods ...string) *RouteBuilder {
+	return b.Match(MatchMethod(methods...))
+}
+
+// Header adds MatchHeader(field, values...) to the route.
+func (b *RouteBuilder) Header(field string, values ...string) *RouteBuilder {
+	return b.Match(MatchHeader(field, values...))
+}
+
+// Match adds matchers to the current matcher set of the route, all of which have to match for the set to match.
+// Hosts, paths, methods, header fields and the like add up with the ones added before, while other matchers
+// replace the matchers of the same name.
+func (b *RouteBuilder) Match(matchers ...Matcher) *RouteBuilder {
+	if len(b.sets) == 0 {
+		b.sets = append(b.sets, nil)
+	}
+	b.sets[len(b.sets)-1] = b.sets[len(b.sets)-1].add(matchers...)
+	return b
+}
+
+// Or starts a new matcher set, so that the route handles requests matching either the matchers added
+// before or the ones added after:
+//
+//	NewRoute().Host("example.com").Or().Host("example.net").Path("/legacy/*")
+func (b *RouteBuilder) Or() *RouteBuilder {
+	if len(b.sets) > 0 && len(b.sets[len(b.sets)-1]) > 0 {
+		b.sets = append(b.sets, nil)
+	}
+	return b
+}
+
+// Handle adds handlers to the route, which handle requests in the order they are added.
+func (b *RouteBuilder) Handle(handlers ...Handler) *RouteBuilder {
+	b.handlers = append(b.handlers, handlers...)
+	return b
+}
+
+// Terminal makes the route the last one to handle the requests it matches.
+func (b *RouteBuilder) Terminal() *RouteBuilder {
+	b.terminal = true
+	return b
+}
+
+// Group puts the route into group, out of which only the first matching route handles a request.
+func (b *RouteBuilder) Group(group string) *RouteBuilder {
+	b.group = group
+	return b
+}
+
+// Build returns the route built so far, or an error if any of its matchers or handlers fails to encode.
+// The builder may be used further to build other routes.
+func (b *RouteBuilder) Build() (*caddyhttp.Route, error) {
+	route := caddyhttp.Route{
+		Group:    b.group,
+		Terminal: b.terminal,
+	}
+	for _, set := range b.sets {
+		if len(set) > 0 {
+			m, err := set.moduleMap()
+			if err != nil {
+				return nil, err
+			}
+			route.MatcherSetsRaw = append(route.MatcherSetsRaw, m)
+		}
+	}
+	for i, h := range b.handlers {
+		raw, err := json.Marshal(h)
+		if err != nil {
+			return nil, fmt.Errorf("encoding handler %v %q: %w", i, h.HandlerName(), err)
+		}
+		route.HandlersRaw = append(route.HandlersRaw, raw)
+	}
+	return &route, nil
+}
+
+// ReverseProxy returns a "reverse_proxy" handler proxying requests to upstreams, such as "localhost:8080".
+// Other settings may be put into the returned handler before passing it to RouteBuilder.Handle.
+func ReverseProxy(upstreams ...string) *ReverseProxyHandler {
+	h := &ReverseProxyHandler{}
+	for _, u := range upstreams {
+		h.Upstreams = append(h.Upstreams, Upstream{Dial: u})
+	}
+	return h
+}
+
+// StaticResponse returns a "static_response" handler responding with statusCode, or 200 if zero, and body.
+func StaticResponse(statusCode int, body string) *StaticResponseHandler {
+	h := &StaticResponseHandler{Body: body}
+	if statusCode != 0 {
+		h.StatusCode = json.RawMessage(strconv.Itoa(statusCode))
+	}
+	return h
+}
+
+// ModuleHandler makes a Handler out of the configuration of any Caddy handler module, such as reverseproxy.Handler
+// or one of a plugin, named after the module.
+func ModuleHandler(m caddy.Module) Handler {
+	name := m.CaddyModule().ID.Name()
+	return &RawHandler{Name: name, Raw: caddyconfig.JSONModuleObject(m, "handler", name, nil)}
+}
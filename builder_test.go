@@ -0,0 +1,85 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+func TestRouteBuilder(t *testing.T) {
+	route, err := NewRoute().
+		Host("example.com").
+		Path("/api/*").
+		Method(http.MethodGet).
+		Header("x-tenant", "a").
+		Host("www.example.com").
+		Method(http.MethodHead).
+		Header("X-Tenant", "b").
+		Header("Authorization").
+		Handle(StaticResponse(0, "hello"), ReverseProxy("localhost:8080", "localhost:8081")).
+		Handle(ModuleHandler(reverseproxy.Handler{
+			TransportRaw: caddyconfig.JSONModuleObject(reverseproxy.HTTPTransport{}, "protocol", "http", nil),
+			Upstreams:    reverseproxy.UpstreamPool{{Dial: "localhost:9000"}},
+		})).
+		Terminal().
+		Build()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{
+		"match": [{
+			"host": ["example.com", "www.example.com"],
+			"path": ["/api/*"],
+			"method": ["GET", "HEAD"],
+			"header": {"X-Tenant": ["a", "b"], "Authorization": []}
+		}],
+		"handle": [
+			{"handler": "static_response", "body": "hello"},
+			{"handler": "reverse_proxy", "upstreams": [{"dial": "localhost:8080"}, {"dial": "localhost:8081"}]},
+			{"handler": "reverse_proxy", "transport": {"protocol": "http"}, "upstreams": [{"dial": "localhost:9000"}]}
+		],
+		"terminal": true
+	}`
+	if !EqualJSON(b, []byte(want)) {
+		t.Errorf("Unexpected route %s", b)
+	}
+
+	grouped, err := NewRoute().Group("g").Handle(StaticResponse(http.StatusNotFound, "")).Build()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, _ := json.Marshal(grouped); !EqualJSON(b, []byte(`{"group":"g","handle":[{"handler":"static_response","status_code":404}]}`)) {
+		t.Errorf("Unexpected route %s", b)
+	}
+
+	// Handlers and matchers that fail to encode fail the route.
+	if _, err := NewRoute().Handle(&RawHandler{Name: "broken", Raw: json.RawMessage("{")}).Build(); err == nil {
+		t.Errorf("Expected an error for a broken handler")
+	}
+	if _, err := NewRoute().Match(MatchNot(ModuleMatcher(brokenMatcher{}))).Build(); err == nil {
+		t.Errorf("Expected an error for a broken matcher")
+	}
+
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	if err := caddyCfg.AddRoute("myserver", "example.com", route, VerifyRoute()); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// brokenMatcher is a matcher module that fails to encode.
+type brokenMatcher struct {
+	Invalid func() `json:"invalid"`
+}
+
+func (brokenMatcher) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{ID: "http.matchers.broken"}
+}
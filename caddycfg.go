@@ -1,7 +1,7 @@
 package caddycfg
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +11,6 @@ import (
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -24,89 +23,282 @@ import (
 const CaddyConfigURL = "http://localhost:2019"
 
 type CaddyCfg struct {
-	configURL httpcaddyfile.Address
+	configURL  httpcaddyfile.Address
+	socket     string // unix socket path of the admin endpoint, if it listens on one
+	options    options
+	httpClient *http.Client
+	ifMatch    string // ETag sent along with every write, see IfMatch
 }
 
 // NewCaddyCfg creates Caddy's configuration, with Caddy configuration url as argument.
 //
 // For default "http://localhost:2019" configuration use NewCaddyCfg(CaddyConfigURL).
-func NewCaddyCfg(configURL string) *CaddyCfg {
-	a, err := httpcaddyfile.ParseAddress(configURL)
+//
+// Admin endpoints listening on a unix socket are specified the same way Caddy's "admin"."listen" accepts them,
+// for instance "unix//run/caddy-admin.sock". Every request is then dialed through that socket,
+// which needs the transport to be an *http.Transport, see WithTransport.
+//
+// By default all the admin requests are made with http.DefaultClient. Use opts
+// such as WithHTTPClient, WithTransport or WithTimeout to change that.
+//
+// NewCaddyCfg panics if configURL is invalid or can't be used with opts. Use New when configURL comes from user input.
+func NewCaddyCfg(configURL string, opts ...Option) *CaddyCfg {
+	c, err := New(configURL, opts...)
 	if err != nil {
 		panic(err) // Panicking is justified here. See time.NewTicker for an example.
 	}
+	return c
+}
+
+// New is the same as NewCaddyCfg, only it reports an invalid configURL, or a unix socket with a transport
+// that can't dial it, with an *AddressError instead of panicking.
+func New(configURL string, opts ...Option) (*CaddyCfg, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if socket, ok := unixSocketPath(configURL); ok {
+		if strings.TrimSpace(socket) == "" {
+			return nil, newAddressError(configURL, ErrInvalidSocketPath, "empty path")
+		}
+		httpClient, err := o.newHTTPClient(socket)
+		if err != nil {
+			return nil, newAddressError(configURL, ErrSocketTransport, err.Error())
+		}
+		return &CaddyCfg{
+			configURL:  socketConfigURL,
+			socket:     socket,
+			options:    o,
+			httpClient: httpClient,
+		}, nil
+	}
+	a, err := httpcaddyfile.ParseAddress(configURL)
+	if err != nil {
+		return nil, newAddressError(configURL, ErrInvalidPort, err.Error())
+	}
+	if err := validateAddress(configURL, a); err != nil {
+		return nil, err
+	}
 	aa := a.String() // this adds "http"
 	r, _ := httpcaddyfile.ParseAddress(aa)
+	httpClient, _ := o.newHTTPClient("") // only dialing a socket fails
 	return &CaddyCfg{
-		configURL: r,
+		configURL:  r,
+		options:    o,
+		httpClient: httpClient,
+	}, nil
+}
+
+// validateAddress checks the parts of a, which was parsed from configURL, that httpcaddyfile.ParseAddress lets through.
+func validateAddress(configURL string, a httpcaddyfile.Address) error {
+	switch a.Scheme {
+	case "", "http", "https":
+	default:
+		return newAddressError(configURL, ErrInvalidScheme, fmt.Sprintf("'%v' is neither http nor https", a.Scheme))
 	}
+	if a.Host == "" {
+		return newAddressError(configURL, ErrInvalidHost, "empty host")
+	}
+	if strings.ContainsAny(a.Host, " \t:@[]") && net.ParseIP(a.Host) == nil {
+		return newAddressError(configURL, ErrInvalidHost, fmt.Sprintf("'%v'", a.Host))
+	}
+	if a.Port != "" {
+		if port, _ := strconv.Atoi(a.Port); port < 1 {
+			return newAddressError(configURL, ErrInvalidPort, fmt.Sprintf("port %v is out of range", a.Port))
+		}
+	}
+	return nil
+}
+
+// socketConfigURL is the address used in requests to an admin endpoint listening on a unix socket.
+// Caddy accepts "127.0.0.1" as the Host of such requests, while the connection itself always goes to the socket.
+var socketConfigURL = httpcaddyfile.Address{Scheme: "http", Host: "127.0.0.1"}
+
+// unixSocketPath returns the socket path of configURL in "unix/<path>" form.
+func unixSocketPath(configURL string) (string, bool) {
+	network, host, _, err := caddy.SplitNetworkAddress(configURL)
+	if err != nil || network != "unix" {
+		return "", false
+	}
+	return host, true
 }
 
 var (
-	// ErrNotFoundID is a base error to what errNotFoundID leads to when unwrapped,
-	// in order to check with errors.Is(err, ErrNotFoundID)
+	// ErrNotFoundID is what an *APIError matches with errors.Is(err, ErrNotFoundID)
+	// when Caddy doesn't know the requested "@id".
 	ErrNotFoundID = errors.New("unknown object ID")
+	// ErrNotFound is what an *APIError with 404 Not Found status matches with errors.Is.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is what an *APIError with 412 Precondition Failed status matches with errors.Is.
+	// Caddy responds with it when the config has changed since the "If-Match" ETag was obtained.
+	ErrConflict = errors.New("conflict")
+	// ErrBadRequest is what an *APIError with 400 Bad Request status matches with errors.Is.
+	// Caddy responds with it to malformed requests and configurations that fail to load.
+	ErrBadRequest = errors.New("bad request")
+	// ErrServerError is what an *APIError with any 5xx status matches with errors.Is.
+	ErrServerError = errors.New("server error")
 )
 
-// errNotFoundID stands for errors of not found object id,
-// it contains id when printed with Error, and unwraps to ErrNotFoundID to be checked using
-// errors.Is(err, ErrNotFoundID)
-type errNotFoundID struct {
-	id string
+// APIError is returned by every call to Caddy's admin API that gets a non-2xx response.
+//
+// Use errors.Is with ErrNotFound, ErrNotFoundID, ErrConflict, ErrBadRequest or ErrServerError
+// to check for the kind of the error, or errors.As to get to the details.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Method is the HTTP method of the request.
+	Method string
+	// Path is the URL path of the request, such as "/id/example.com".
+	Path string
+	// Message is the "error" field of Caddy's {"error": "..."} response,
+	// or the response body as is if it is not in that form.
+	Message string
+}
+
+// newAPIError creates *APIError out of a response to a request made with method to url.
+func newAPIError(method string, url *url.URL, statusCode int, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: statusCode,
+		Method:     method,
+		Path:       url.Path,
+	}
+	var msg struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &msg); err == nil && msg.Error != "" {
+		e.Message = msg.Error
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+func (a *APIError) Error() string {
+	if a.Message == "" {
+		return fmt.Sprintf("%v %v: %d %v", a.Method, a.Path, a.StatusCode, http.StatusText(a.StatusCode))
+	}
+	return fmt.Sprintf("%v %v: %d %v", a.Method, a.Path, a.StatusCode, a.Message)
 }
 
-func newNotFoundID(id string) *errNotFoundID {
-	return &errNotFoundID{
-		id: id,
+// Is makes errors.Is match the error against ErrNotFound, ErrNotFoundID, ErrConflict, ErrBadRequest
+// and ErrServerError according to the status code.
+func (a *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return a.StatusCode == http.StatusNotFound
+	case ErrNotFoundID:
+		return a.StatusCode == http.StatusNotFound && strings.HasPrefix(a.Message, ErrNotFoundID.Error())
+	case ErrConflict:
+		return a.StatusCode == http.StatusPreconditionFailed
+	case ErrBadRequest:
+		return a.StatusCode == http.StatusBadRequest
+	case ErrServerError:
+		return a.StatusCode >= 500
 	}
+	return false
+}
+
+var (
+	// ErrInvalidScheme is what an *AddressError unwraps to when the admin address has a scheme other than http or https.
+	ErrInvalidScheme = errors.New("invalid scheme")
+	// ErrInvalidHost is what an *AddressError unwraps to when the admin address has a missing or malformed host.
+	ErrInvalidHost = errors.New("invalid host")
+	// ErrInvalidPort is what an *AddressError unwraps to when the admin address has a non-numeric or out of range port.
+	ErrInvalidPort = errors.New("invalid port")
+	// ErrInvalidSocketPath is what an *AddressError unwraps to when the admin address is a unix socket with an empty path.
+	ErrInvalidSocketPath = errors.New("invalid socket path")
+	// ErrSocketTransport is what an *AddressError unwraps to when the admin address is a unix socket,
+	// but the transport set with WithTransport or WithHTTPClient is not an *http.Transport, see WithTransport.
+	ErrSocketTransport = errors.New("transport can't dial unix socket")
+)
+
+// AddressError is returned by New for admin addresses that can't be used.
+// It unwraps to one of ErrInvalidScheme, ErrInvalidHost, ErrInvalidPort, ErrInvalidSocketPath or ErrSocketTransport,
+// to be checked using errors.Is.
+type AddressError struct {
+	// Address is the admin address as it was passed to New.
+	Address string
+	// Err is the kind of the problem.
+	Err error
+	// Detail describes the problem in the address.
+	Detail string
 }
 
-func (n errNotFoundID) Error() string {
-	return fmt.Sprintf("not found ID '%v'", n.id)
+func newAddressError(address string, err error, detail string) *AddressError {
+	return &AddressError{
+		Address: address,
+		Err:     err,
+		Detail:  detail,
+	}
 }
 
-func (n errNotFoundID) Unwrap() error {
-	return ErrNotFoundID
+func (a *AddressError) Error() string {
+	if a.Detail == "" {
+		return fmt.Sprintf("%v in admin address '%v'", a.Err, a.Address)
+	}
+	return fmt.Sprintf("%v in admin address '%v': %v", a.Err, a.Address, a.Detail)
 }
 
-const messageErrorUnknownObjectIDPrefix = "{\"error\":\"unknown object ID"
+func (a *AddressError) Unwrap() error {
+	return a.Err
+}
 
 // UploadTo does the same as Upload, only to a custom configURL, which usually equals CaddyConfigURL.
 // This allows for uploading a new configuration on top of an empty `caddy run` that started with a 'null' configuration.
 //
 // If configJSON contains a new "admin:listen" section, it seems to retarget Caddy's configURL to it for any next configuration manipulations.
+//
+// configURL may also be a unix socket address such as "unix//run/caddy-admin.sock".
 func (caddyCfg *CaddyCfg) UploadTo(configURL string, configJSON string) error {
-	r, err := http.Post(JoinURLPath(configURL, "load"), "application/json", strings.NewReader(configJSON))
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
-	b, err := io.ReadAll(r.Body)
-	if err != nil {
-		return err
+	return caddyCfg.UploadToContext(context.Background(), configURL, configJSON)
+}
+
+// UploadToContext is like UploadTo, but the request is bound to ctx.
+func (caddyCfg *CaddyCfg) UploadToContext(ctx context.Context, configURL string, configJSON string) error {
+	client, baseURL := caddyCfg.httpClient, configURL
+	if socket, ok := unixSocketPath(configURL); ok {
+		if socket != caddyCfg.socket {
+			var err error
+			if client, err = caddyCfg.options.newHTTPClient(socket); err != nil {
+				return newAddressError(configURL, ErrSocketTransport, err.Error())
+			}
+		}
+		baseURL = socketConfigURL.String()
+	} else if caddyCfg.socket != "" {
+		client, _ = caddyCfg.options.newHTTPClient("") // only dialing a socket fails
 	}
-	v := string(b)
-	if v != "" {
-		return errors.New(v)
+	loadURL := JoinURLPath(baseURL, "load")
+	if caddyCfg.ifMatch != "" {
+		// "/load" ignores "If-Match", while replacing the root of "/config/" honours it.
+		loadURL = JoinURLPath(baseURL, "config") + "/"
 	}
-	return nil
+	_, _, err := caddyCfg.send(ctx, client, http.MethodPost, loadURL, strings.NewReader(configJSON))
+	return err
 }
 
 // Upload (in Caddy terms "load") is sending full configuration that will replace
 // the existing one completely. It might be good for a base configuration.
 func (caddyCfg *CaddyCfg) Upload(configJSON string) error {
-	return caddyCfg.UploadTo(caddyCfg.configURL.String(), configJSON)
+	return caddyCfg.UploadContext(context.Background(), configJSON)
+}
+
+// UploadContext is like Upload, but the request is bound to ctx.
+func (caddyCfg *CaddyCfg) UploadContext(ctx context.Context, configJSON string) error {
+	if caddyCfg.socket != "" {
+		return caddyCfg.UploadToContext(ctx, caddyCfg.listenAddress(), configJSON)
+	}
+	return caddyCfg.UploadToContext(ctx, caddyCfg.configURL.String(), configJSON)
 }
 
 // Config returns full configuration of CaddyCfg, including
 // root node. Trailing "\n" will be removed.
 func (caddyCfg *CaddyCfg) Config() (string, error) {
-	loadConfig, err := http.Get(JoinURLPath(caddyCfg.configURL.String(), "config"))
-	if err != nil {
-		return "", err
-	}
-	defer loadConfig.Body.Close()
-	b, err := io.ReadAll(loadConfig.Body)
+	return caddyCfg.ConfigContext(context.Background())
+}
+
+// ConfigContext is like Config, but the request is bound to ctx.
+func (caddyCfg *CaddyCfg) ConfigContext(ctx context.Context) (string, error) {
+	b, err := caddyCfg.do(ctx, http.MethodGet, JoinURLPath(caddyCfg.configURL.String(), "config"), nil)
 	if err != nil {
 		return "", err
 	}
@@ -116,69 +308,131 @@ func (caddyCfg *CaddyCfg) Config() (string, error) {
 // ConfigById returns configuration section belonging to a marked by "@id" section in a JSON string format.
 // Trailing "\n" will be removed.
 //
-// If not finding the object by id error occurs, it will be converted into a errNotFoundID.
+// If the object is not found by id, the returned *APIError matches errors.Is(err, ErrNotFoundID).
 func (caddyCfg *CaddyCfg) ConfigById(id string) (string, error) {
-	loadConfig, err := http.Get(JoinURLPath(caddyCfg.configURL.String(), "id", url.PathEscape(id)))
-	if err != nil {
-		return "", err
-	}
-	defer loadConfig.Body.Close()
-	b, err := io.ReadAll(loadConfig.Body)
+	return caddyCfg.ConfigByIdContext(context.Background(), id)
+}
+
+// ConfigByIdContext is like ConfigById, but the request is bound to ctx.
+func (caddyCfg *CaddyCfg) ConfigByIdContext(ctx context.Context, id string) (string, error) {
+	b, err := caddyCfg.do(ctx, http.MethodGet, JoinURLPath(caddyCfg.configURL.String(), "id", url.PathEscape(id)), nil)
 	if err != nil {
 		return "", err
 	}
-	s := string(b)
-
-	if strings.HasPrefix(s, messageErrorUnknownObjectIDPrefix) {
-		return "", newNotFoundID(id)
-	}
-	return strings.TrimSuffix(s, "\n"), nil
+	return strings.TrimSuffix(string(b), "\n"), nil
 }
 
 // DeleteById attempts to delete a config by specified id. In theory this should work
 // for any section of configuration, but here it's only used to remove routes.
 //
-// If not finding the object by id error occurs, it will be converted into a errNotFoundID.
+// If the object is not found by id, the returned *APIError matches errors.Is(err, ErrNotFoundID).
 func (caddyCfg *CaddyCfg) DeleteById(id string) error {
-	client := http.DefaultClient
-	configUrl := JoinURLPath(caddyCfg.configURL.String(), "id", url.PathEscape(id))
+	return caddyCfg.DeleteByIdContext(context.Background(), id)
+}
+
+// DeleteByIdContext is like DeleteById, but the request is bound to ctx.
+//
+// path optionally continues into the section to delete only a part of it. See GetByID for the format of path.
+func (caddyCfg *CaddyCfg) DeleteByIdContext(ctx context.Context, id string, path ...string) error {
+	_, err := caddyCfg.do(ctx, http.MethodDelete, caddyCfg.pathURL("id", append([]string{id}, path...)...), nil)
+	return err
+}
 
-	req, err := http.NewRequest(http.MethodDelete, configUrl, bytes.NewBuffer(nil))
-	//req.Header.Set("Content-Type", "application/json")
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
+// do sends a single request to Caddy's admin endpoint and returns the response body.
+// Responses with a non-2xx status code are returned as *APIError.
+//
+// When the request fails because ctx was canceled or its deadline passed, ctx.Err() is returned as is,
+// so that errors.Is(err, context.Canceled) and errors.Is(err, context.DeadlineExceeded) can tell it apart
+// from errors reported by Caddy.
+func (caddyCfg *CaddyCfg) do(ctx context.Context, method string, url string, body io.Reader) ([]byte, error) {
+	b, _, err := caddyCfg.send(ctx, caddyCfg.httpClient, method, url, body)
+	return b, err
+}
 
+// send is do with an explicit client, which also returns the ETag of the response, if any.
+//
+// Unless method is GET, the request carries "If-Match" header set with IfMatch.
+func (caddyCfg *CaddyCfg) send(ctx context.Context, client *http.Client, method string, url string, body io.Reader) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if caddyCfg.ifMatch != "" && method != http.MethodGet {
+		req.Header.Set("If-Match", caddyCfg.ifMatch)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		return nil, "", err
 	}
-
 	defer resp.Body.Close()
-
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, "", ctxErr
+		}
+		return nil, "", err
 	}
-	s := string(b)
-	if strings.HasPrefix(s, messageErrorUnknownObjectIDPrefix) {
-		return newNotFoundID(id)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", newAPIError(method, req.URL, resp.StatusCode, b)
 	}
-	return nil
+	// Caddy sends ETag as a trailer, which is only available once the body is read.
+	etag := resp.Trailer.Get("Etag")
+	if etag == "" {
+		etag = resp.Header.Get("Etag")
+	}
+	return b, etag, nil
 }
 
 type IDField struct {
 	Id string `json:"@id"`
 }
 
+// ErrRouteNotApplied is what a *RouteError unwraps to when the route couldn't be found in Caddy's configuration
+// after it was written. See VerifyRoute.
+var ErrRouteNotApplied = errors.New("route not applied")
+
+// RouteError is returned by AddRoute when the route couldn't be put into Caddy's configuration.
+// It unwraps to the underlying error, which is usually an *APIError carrying Caddy's explanation.
+type RouteError struct {
+	// ServerKey is the "apps"."http"."servers" entry the route was added to.
+	ServerKey string
+	// RouteID is the "@id" of the route.
+	RouteID string
+	// Err is the reason the route wasn't added.
+	Err error
+}
+
+func newRouteError(serverKey string, routeId string, err error) *RouteError {
+	return &RouteError{
+		ServerKey: serverKey,
+		RouteID:   routeId,
+		Err:       err,
+	}
+}
+
+func (r *RouteError) Error() string {
+	return fmt.Sprintf("adding route '%v' to server '%v': %v", r.RouteID, r.ServerKey, r.Err)
+}
+
+func (r *RouteError) Unwrap() error {
+	return r.Err
+}
+
 // AddRoute ensures that configuration marked by unique route config "@id" field specified by routeId enters Caddy's configuration.
 // A good candidate for routeId is a domain name.
 //
 // To avoid any downtime, this function first pokes Caddy for current configuration on "@id" to see if it matches routeConfig
-// either byte-to-byte or by structure. This allows to skip unnecessary deletion/addition described below.
+// either byte-to-byte or by structure. This allows to skip unnecessary replacement described below.
 //
-// In case configuration is not found or doesn't match, it will be attempted to be deleted using "@id" key (ignoring errors)
-// and then get added, to keep only one configuration for this routeId.
+// In case configuration doesn't match, it gets replaced in place using "@id" key, so that the route keeps its position
+// and there's no moment at which Caddy has no route for routeId. In case configuration is not found, it gets added
+// to the end of the server's routes, unless Before, After, AtIndex or Priority tell otherwise.
 //
 // serverKey is an arbitrary name in the base configuration for the "apps"."http"."servers" entry. Default value is usually "myserver".
 //
@@ -190,11 +444,65 @@ type IDField struct {
 //				"servers": {
 //					"<serverKey>":
 //
-func (caddyCfg *CaddyCfg) AddRoute(serverKey string, routeId string, routeConfig *caddyhttp.Route) error {
-	config, err := json.Marshal(routeConfig)
+// Failures are reported as *RouteError. See RouteOption for the ways to tweak the behavior.
+func (caddyCfg *CaddyCfg) AddRoute(serverKey string, routeId string, routeConfig *caddyhttp.Route, opts ...RouteOption) error {
+	return caddyCfg.AddRouteContext(context.Background(), serverKey, routeId, routeConfig, opts...)
+}
+
+// AddRouteContext is like AddRoute, but all the requests it makes are bound to ctx.
+func (caddyCfg *CaddyCfg) AddRouteContext(ctx context.Context, serverKey string, routeId string, routeConfig *caddyhttp.Route, opts ...RouteOption) error {
+	var o routeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	err := caddyCfg.addRoute(ctx, serverKey, routeId, routeConfig, o)
+	if err != nil {
+		return newRouteError(serverKey, routeId, err)
+	}
+	return nil
+}
+
+func (caddyCfg *CaddyCfg) addRoute(ctx context.Context, serverKey string, routeId string, routeConfig *caddyhttp.Route, o routeOptions) error {
+	cfg, err := routeJSON(routeId, routeConfig, o.meta())
+	if err != nil {
+		return err
+	}
+
+	var replaced string
+	for attempt := 1; ; attempt++ {
+		if o.place != nil {
+			replaced, err = caddyCfg.placeRoute(ctx, serverKey, routeId, cfg, o.place)
+		} else {
+			replaced, err = caddyCfg.putRoute(ctx, serverKey, routeId, cfg)
+		}
+		if errors.Is(err, ErrConflict) && caddyCfg.ifMatch == "" && attempt < maxConflictRetries {
+			// Someone else has changed the route since we've looked it up.
+			continue
+		}
+		break
+	}
 	if err != nil {
 		return err
 	}
+	if o.report != nil && replaced != "" {
+		// A renewed lease is not a change worth reporting, or every heartbeat would be.
+		from, to := withoutExpires(replaced), withoutExpires(cfg)
+		if changes := Diff([]byte(from), []byte(to), IgnoreDefaults(ExplicitFields...), UnorderedArrays(SetLikeArrays...)); len(changes) > 0 {
+			o.report(changes)
+		}
+	}
+	if o.verify {
+		return caddyCfg.verifyRoute(ctx, routeId, cfg)
+	}
+	return nil
+}
+
+// routeJSON marshals routeConfig into JSON with "@id" routeId and metadata meta.
+func routeJSON(routeId string, routeConfig *caddyhttp.Route, meta routeMeta) (string, error) {
+	config, err := json.Marshal(routeConfig)
+	if err != nil {
+		return "", err
+	}
 
 	cfg := string(config)
 
@@ -202,33 +510,80 @@ func (caddyCfg *CaddyCfg) AddRoute(serverKey string, routeId string, routeConfig
 	// field for it.
 	cfg = strings.Replace(cfg, "{", fmt.Sprintf("{%v,", EncodeAtId(routeId)), 1)
 
-	current, err := caddyCfg.ConfigById(routeId)
-	if err == nil { // including errNotFoundID
-		if RouteConfigsEqual(cfg, current) {
-			return nil
-		}
+	if !meta.empty() {
+		return withRouteMeta(cfg, meta)
 	}
+	return cfg, nil
+}
 
-	if current != "" {
-		_ = caddyCfg.DeleteById(routeId)
+// putRoute makes a single attempt to look up the route by routeId and add or replace it with cfg.
+// It returns the configuration it has replaced, if any.
+//
+// Unless IfMatch is in effect, the replacement is guarded by the ETag of the route that was looked up,
+// so that concurrent changes to it are reported as ErrConflict instead of being overwritten.
+func (caddyCfg *CaddyCfg) putRoute(ctx context.Context, serverKey string, routeId string, cfg string) (string, error) {
+	current, etag, err := caddyCfg.ConfigByIdWithETag(ctx, routeId)
+	switch {
+	case err == nil:
+		if sameRoute(cfg, current) {
+			return "", nil
+		}
+		target := caddyCfg
+		if target.ifMatch == "" {
+			target = caddyCfg.IfMatch(etag)
+		}
+		err = target.replaceRoute(ctx, routeId, cfg)
+		if errors.Is(err, ErrNotFoundID) {
+			// Deleted since we've looked it up.
+			return "", caddyCfg.appendRoute(ctx, serverKey, cfg)
+		}
+		if err != nil {
+			return "", err
+		}
+		return current, nil
+	case errors.Is(err, ErrNotFoundID):
+		err = caddyCfg.appendRoute(ctx, serverKey, cfg)
 	}
+	return "", err
+}
 
-	requestPatch, err := http.Post(
+// replaceRoute atomically swaps configuration under routeId with cfg, keeping it at the same position.
+func (caddyCfg *CaddyCfg) replaceRoute(ctx context.Context, routeId string, cfg string) error {
+	_, err := caddyCfg.do(
+		ctx,
+		http.MethodPatch,
+		JoinURLPath(caddyCfg.configURL.String(), "id", url.PathEscape(routeId)),
+		strings.NewReader(cfg),
+	)
+	return err
+}
+
+// appendRoute adds cfg to the end of the routes of serverKey.
+func (caddyCfg *CaddyCfg) appendRoute(ctx context.Context, serverKey string, cfg string) error {
+	_, err := caddyCfg.do(
+		ctx,
+		http.MethodPost,
 		JoinURLPath(
 			caddyCfg.configURL.String(),
 			"config", "apps", "http", "servers", url.PathEscape(serverKey), "routes",
 		),
-		"application/json",
 		strings.NewReader(cfg),
 	)
-	if err != nil {
-		return err
+	return err
+}
+
+// verifyRoute checks that Caddy's configuration has cfg under routeId.
+func (caddyCfg *CaddyCfg) verifyRoute(ctx context.Context, routeId string, cfg string) error {
+	current, err := caddyCfg.ConfigByIdContext(ctx, routeId)
+	if errors.Is(err, ErrNotFoundID) {
+		return ErrRouteNotApplied
 	}
-	defer requestPatch.Body.Close()
-	_, err = io.ReadAll(requestPatch.Body) // Returns nothing
 	if err != nil {
 		return err
 	}
+	if !sameRoute(cfg, current) {
+		return fmt.Errorf("%w: configuration differs: %v", ErrRouteNotApplied, current)
+	}
 	return nil
 }
 
@@ -238,13 +593,18 @@ func (caddyCfg *CaddyCfg) AddRoute(serverKey string, routeId string, routeConfig
 //	m, err := json.MarshalIndent(route, "", "\t")
 //
 // pathMatch is usually "/*" for matching any paths.
-func ReverseProxyCaddyRouteConf(backendPort int, matchHosts []string, pathMatch string) *caddyhttp.Route {
+//
+// opts tweak the "reverse_proxy" handler, such as with ActiveHealthChecks and PassiveHealthChecks.
+func ReverseProxyCaddyRouteConf(backendPort int, matchHosts []string, pathMatch string, opts ...ProxyOption) *caddyhttp.Route {
 	toAddr, _ := httpcaddyfile.ParseAddress("localhost:" + strconv.Itoa(backendPort))
 	ht := reverseproxy.HTTPTransport{}
 	handler := reverseproxy.Handler{
 		TransportRaw: caddyconfig.JSONModuleObject(ht, "protocol", "http", nil),
 		Upstreams:    reverseproxy.UpstreamPool{{Dial: net.JoinHostPort(toAddr.Host, toAddr.Port)}},
 	}
+	for _, opt := range opts {
+		opt(&handler)
+	}
 	route := caddyhttp.Route{
 		HandlersRaw: []json.RawMessage{
 			caddyconfig.JSONModuleObject(handler, "handler", "reverse_proxy", nil),
@@ -287,14 +647,16 @@ func EncodeJSONString(value string) string {
 //
 // This can be passed to CaddyCfg.Upload as initial empty configuration
 // that might be later enhanced with routes.
+//
+// BaseConfig panics if configURL is invalid. Create CaddyCfg with New and use its BaseConfig method
+// to handle that as an error.
 func BaseConfig(configURL string, serverKey string) string {
-	c := NewCaddyCfg(configURL)
-	// "listen" doesn't like http:// or https://
-	address := c.configURL
-	address.Scheme = ""
-	str := address.String() // still adds http:// or https://
-	str = strings.TrimPrefix(str, "http://")
-	str = strings.TrimPrefix(str, "https://")
+	return NewCaddyCfg(configURL).BaseConfig(serverKey)
+}
+
+// BaseConfig is the same as the BaseConfig function for the admin address of caddyCfg.
+func (caddyCfg *CaddyCfg) BaseConfig(serverKey string) string {
+	str := caddyCfg.listenAddress()
 	return `{
 	"admin": {
 		"listen": ` + EncodeJSONString(str) + `
@@ -318,7 +680,24 @@ func BaseConfig(configURL string, serverKey string) string {
 `
 }
 
-// JoinURLPath ignores any url_ parsing errors
+// listenAddress returns the admin endpoint address in the form "admin"."listen" expects it.
+func (caddyCfg *CaddyCfg) listenAddress() string {
+	if caddyCfg.socket != "" {
+		return "unix/" + caddyCfg.socket
+	}
+	// "listen" doesn't like http:// or https://
+	address := caddyCfg.configURL
+	address.Scheme = ""
+	str := address.String() // still adds http:// or https://
+	str = strings.TrimPrefix(str, "http://")
+	str = strings.TrimPrefix(str, "https://")
+	return str
+}
+
+// JoinURLPath ignores any url_ parsing errors.
+//
+// paths are expected to be escaped already (see url.PathEscape), and are kept that way,
+// so that a path segment may contain escaped "/" or "%".
 func JoinURLPath(url_ string, paths ...string) string {
 	u, err := url.Parse(url_)
 	if err != nil {
@@ -327,22 +706,29 @@ func JoinURLPath(url_ string, paths ...string) string {
 		}
 		return strings.TrimSuffix(url_, "/") + "/" + path.Join(paths...)
 	}
-	u.Path = path.Join(append([]string{u.Path}, paths...)...)
+	joined := path.Join(append([]string{u.EscapedPath()}, paths...)...)
+	if unescaped, err := url.PathUnescape(joined); err == nil {
+		u.Path, u.RawPath = unescaped, joined
+	} else {
+		u.Path, u.RawPath = joined, ""
+	}
 	return u.String()
 }
 
 // Refresher calls the passed refresh first immediately and then continuously after refreshDelay.
 //
 // This will likely run in a separate Goroutine.
+//
+// Deprecated: Refresher can't be stopped and doesn't know about failures. Use Refresh instead.
 func Refresher(refreshDelay time.Duration, refresh func()) {
+	refreshUntil(nil, refreshDelay, refresh)
+}
+
+// refreshUntil is Refresher returning once done is closed. A nil done never closes.
+func refreshUntil(done <-chan struct{}, refreshDelay time.Duration, refresh func()) {
 	ticker := time.NewTicker(refreshDelay)
 	defer ticker.Stop()
 
-	done := make(chan bool)
-	//go func() {
-	//	// done <- true
-	//}()
-
 	refresh()
 	for {
 		select {
@@ -1,13 +1,22 @@
 package caddycfg
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 )
 
 func ExampleEncodeAtId() {
@@ -242,10 +251,322 @@ func ExampleJoinURLPath() {
 	fmt.Println(JoinURLPath("http://localhost:2019/in", "test", "where", "to", "go"))
 	fmt.Println(JoinURLPath("http://localhost:2019/in", "test/where/to/go"))
 	fmt.Println(JoinURLPath("", "test"))
+	fmt.Println(JoinURLPath("http://localhost:2019", "id", url.PathEscape("my route/1")))
 	// Output:
 	// http://localhost:2019/test
 	// http://localhost:2019/test
 	// http://localhost:2019/in/test/where/to/go
 	// http://localhost:2019/in/test/where/to/go
 	// test
+	// http://localhost:2019/id/my%20route%2F1
+}
+
+// newHangingAdmin starts a server that never answers until the request is abandoned by the client.
+func newHangingAdmin() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+}
+
+func TestCaddyCfg_Context(t *testing.T) {
+	srv := newHangingAdmin()
+	defer srv.Close()
+	caddyCfg := NewCaddyCfg(srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := caddyCfg.ConfigContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err = caddyCfg.AddRouteContext(ctx, "myserver", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	for name, call := range map[string]func() error{
+		"UploadContext":     func() error { return caddyCfg.UploadContext(ctx, "{}") },
+		"ConfigByIdContext": func() error { _, err := caddyCfg.ConfigByIdContext(ctx, "example.com"); return err },
+		"DeleteByIdContext": func() error { return caddyCfg.DeleteByIdContext(ctx, "example.com") },
+	} {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%v: expected canceled, got %v", name, err)
+		}
+	}
+}
+
+// newSocketAdmin starts handler on a unix socket and returns the server along with its "unix/<path>" address.
+func newSocketAdmin(t *testing.T, handler http.Handler) (*httptest.Server, string) {
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener = l
+	srv.Start()
+	return srv, "unix/" + socket
+}
+
+func TestCaddyCfg_UnixSocket(t *testing.T) {
+	var requests []string
+	srv, address := newSocketAdmin(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte("null\n"))
+		}
+	}))
+	defer srv.Close()
+
+	caddyCfg := NewCaddyCfg(address)
+	c, err := caddyCfg.Config()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c != "null" {
+		t.Errorf("Expected null config, got %v", c)
+	}
+	if err := caddyCfg.Upload("{}"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := NewCaddyCfg(CaddyConfigURL).UploadTo(address, "{}"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{"GET /config", "POST /load", "POST /load"}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected requests %v, got %v", want, requests)
+	}
+
+	// Requests of transports that can't dial the socket would go elsewhere.
+	logging := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	if _, err := New(address, WithTransport(logging)); !errors.Is(err, ErrSocketTransport) {
+		t.Errorf("Expected ErrSocketTransport, got %v", err)
+	}
+	if _, err := New(address, WithHTTPClient(&http.Client{Transport: logging})); !errors.Is(err, ErrSocketTransport) {
+		t.Errorf("Expected ErrSocketTransport, got %v", err)
+	}
+	if err := NewCaddyCfg(CaddyConfigURL, WithTransport(logging)).UploadTo(address, "{}"); !errors.Is(err, ErrSocketTransport) {
+		t.Errorf("Expected ErrSocketTransport, got %v", err)
+	}
+	viaTransport, err := New(address, WithTransport(&http.Transport{}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := viaTransport.Config(); err != nil {
+		t.Errorf("%v", err)
+	}
+	if want := append(want, "GET /config"); strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected requests %v, got %v", want, requests)
+	}
+
+	base := BaseConfig("unix//run/caddy-admin.sock", "myserver")
+	if !strings.Contains(base, `"listen": "unix//run/caddy-admin.sock"`) {
+		t.Errorf("Expected unix socket admin listen address, got:\n%v", base)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		configURL string
+		want      error
+	}{
+		{CaddyConfigURL, nil},
+		{"localhost:2019", nil},
+		{"https://caddy.example.com", nil},
+		{"http://[::1]:2019", nil},
+		{"unix//run/caddy-admin.sock", nil},
+		{"ftp://localhost:2019", ErrInvalidScheme},
+		{"http://", ErrInvalidHost},
+		{"http://:2019", ErrInvalidHost},
+		{"http://local host:2019", ErrInvalidHost},
+		{"localhost:port", ErrInvalidPort},
+		{"localhost:70000", ErrInvalidPort},
+		{"localhost:0", ErrInvalidPort},
+		{"unix/", ErrInvalidSocketPath},
+	} {
+		c, err := New(tt.configURL)
+		if tt.want == nil {
+			if err != nil || c == nil {
+				t.Errorf("%v: expected no error, got %v", tt.configURL, err)
+			}
+			continue
+		}
+		var addressErr *AddressError
+		if !errors.As(err, &addressErr) || !errors.Is(err, tt.want) {
+			t.Errorf("%v: expected *AddressError of %v, got %v", tt.configURL, tt.want, err)
+		} else if addressErr.Address != tt.configURL {
+			t.Errorf("%v: expected address in error, got %v", tt.configURL, addressErr.Address)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected NewCaddyCfg to panic on invalid address")
+		}
+	}()
+	NewCaddyCfg("ftp://localhost:2019")
+}
+
+func TestAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/id/"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"unknown object ID 'example.com'"}` + "\n"))
+		case r.URL.Path == "/load":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"loading config: decoding request body: invalid character 'x' looking for beginning of value"}` + "\n"))
+		case r.URL.Path == "/config":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("oops"))
+		default:
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+	}))
+	defer srv.Close()
+	caddyCfg := NewCaddyCfg(srv.URL)
+
+	_, err := caddyCfg.ConfigById("example.com")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Method != http.MethodGet || apiErr.Path != "/id/example.com" || apiErr.Message != "unknown object ID 'example.com'" {
+		t.Errorf("Unexpected error details: %#v", apiErr)
+	}
+	if !errors.Is(err, ErrNotFoundID) || !errors.Is(err, ErrNotFound) || errors.Is(err, ErrBadRequest) {
+		t.Errorf("Expected not found ID error, got %v", err)
+	}
+	if err := caddyCfg.DeleteById("example.com"); !errors.Is(err, ErrNotFoundID) {
+		t.Errorf("Expected not found ID error, got %v", err)
+	}
+
+	err = caddyCfg.Upload("x")
+	if !errors.Is(err, ErrBadRequest) || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected bad request error, got %v", err)
+	}
+	if want := "POST /load: 400 loading config: decoding request body: invalid character 'x' looking for beginning of value"; err.Error() != want {
+		t.Errorf("Expected error message:\n%v\ngot:\n%v", want, err)
+	}
+
+	_, err = caddyCfg.Config()
+	if !errors.Is(err, ErrServerError) || !errors.As(err, &apiErr) || apiErr.Message != "oops" {
+		t.Errorf("Expected server error, got %v", err)
+	}
+
+	err = caddyCfg.AddRoute("myserver", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict error, got %v", err)
+	}
+	if want := "adding route 'example.com' to server 'myserver': POST /config/apps/http/servers/myserver/routes: 412 Precondition Failed"; err.Error() != want {
+		t.Errorf("Expected error message:\n%v\ngot:\n%v", want, err)
+	}
+}
+
+func TestCaddyCfg_AddRoute_Errors(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	admin.reject = func(config any) error {
+		b, _ := json.Marshal(config)
+		if strings.Contains(string(b), `"handler":"invalid"`) {
+			return errors.New("loading new config: unknown module: http.handlers.invalid")
+		}
+		return nil
+	}
+	caddyCfg := NewCaddyCfg(admin.URL)
+
+	err := caddyCfg.AddRoute("unknown", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*"))
+	var routeErr *RouteError
+	if !errors.As(err, &routeErr) || routeErr.ServerKey != "unknown" || routeErr.RouteID != "example.com" {
+		t.Fatalf("Expected *RouteError, got %v", err)
+	}
+	if !errors.Is(err, ErrServerError) {
+		t.Errorf("Expected server error for unknown server key, got %v", err)
+	}
+
+	invalid := &caddyhttp.Route{HandlersRaw: []json.RawMessage{json.RawMessage(`{"handler":"invalid"}`)}}
+	err = caddyCfg.AddRoute("myserver", "example.com", invalid)
+	var apiErr *APIError
+	if !errors.Is(err, ErrBadRequest) || !errors.As(err, &apiErr) || apiErr.Message != "loading new config: unknown module: http.handlers.invalid" {
+		t.Errorf("Expected bad request error, got %v", err)
+	}
+
+	err = caddyCfg.AddRoute("myserver", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*"), VerifyRoute())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{"admin":{"listen":"localhost:2019"},"apps":{"http":{"servers":{"myserver":{"automatic_https":{"skip":[]},"listen":[":443"],"routes":[{"@id":"example.com","handle":[{"handler":"reverse_proxy","transport":{"protocol":"http"},"upstreams":[{"dial":"localhost:8080"}]}],"match":[{"host":["example.com"],"path":["/*"]}]}]}}}}}`
+	if c := admin.config(); c != want {
+		t.Errorf("Config error, want:\n%v\ngot:\n%v", want, c)
+	}
+}
+
+func TestCaddyCfg_AddRoute_VerifyRoute(t *testing.T) {
+	// An admin endpoint accepting the route but never applying it.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeFakeError(w, http.StatusNotFound, fmt.Errorf("unknown object ID 'example.com'"))
+		}
+	}))
+	defer srv.Close()
+	caddyCfg := NewCaddyCfg(srv.URL)
+	route := ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*")
+	if err := caddyCfg.AddRoute("myserver", "example.com", route); err != nil {
+		t.Errorf("Expected no error without verification, got %v", err)
+	}
+	if err := caddyCfg.AddRoute("myserver", "example.com", route, VerifyRoute()); !errors.Is(err, ErrRouteNotApplied) {
+		t.Errorf("Expected route not applied error, got %v", err)
+	}
+}
+
+func TestCaddyCfg_AddRoute_InPlace(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	for i, id := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if err := caddyCfg.AddRoute("myserver", id, ReverseProxyCaddyRouteConf(8080+i, []string{id}, "/*")); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	admin.served()
+
+	// Unchanged route is left alone.
+	if err := caddyCfg.AddRoute("myserver", "b.example.com", ReverseProxyCaddyRouteConf(8081, []string{"b.example.com"}, "/*")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if served := strings.Join(admin.served(), ","); served != "GET /id/b.example.com" {
+		t.Errorf("Expected a single lookup, got %v", served)
+	}
+
+	// Changed route is swapped in place.
+	if err := caddyCfg.AddRoute("myserver", "b.example.com", ReverseProxyCaddyRouteConf(9000, []string{"b.example.com"}, "/*")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if served := strings.Join(admin.served(), ","); served != "GET /id/b.example.com,PATCH /id/b.example.com" {
+		t.Errorf("Expected lookup and in place replacement, got %v", served)
+	}
+	var cfg struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]struct {
+					Routes []RouteConfigType `json:"routes"`
+				} `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal([]byte(admin.config()), &cfg); err != nil {
+		t.Fatalf("%v", err)
+	}
+	routes := cfg.Apps.HTTP.Servers["myserver"].Routes
+	if len(routes) != 3 || routes[1].Id != "b.example.com" || routes[1].Handle[0].Upstreams[0].Dial != "localhost:9000" {
+		t.Errorf("Expected b.example.com to be replaced at its position, got %+v", routes)
+	}
 }
@@ -1,9 +1,11 @@
 package caddycfg
 
 import (
+	"bytes"
 	"encoding/json"
-	"reflect"
-	"strings"
+	"fmt"
+	"io"
+	"math/big"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
 )
@@ -12,9 +14,11 @@ func _unsuedFunction() {
 	var _ antlr.ATNConfig
 }
 
-// RouteConfigsEqual compares two configurations that can
-// be decoded into RouteConfigType, allowing for shuffled
-// named parameters.
+// RouteConfigsEqual compares two route configurations semantically with EqualJSON,
+// allowing for shuffled named parameters, numbers written differently, default values
+// being left out (except for ExplicitFields) and shuffled hosts, paths and other set-like lists (see SetLikeArrays).
+//
+// Any difference in matchers, handlers or their settings makes them different.
 func RouteConfigsEqual(cfg0, cfg1 string) bool {
 	if cfg0 == cfg1 {
 		// This is rare that they both simply be equal like this, as they seem to be marshalled differently by Caddy
@@ -22,24 +26,192 @@ func RouteConfigsEqual(cfg0, cfg1 string) bool {
 		return true
 	}
 	// We have to try to compare them structurally.
-	decCfg := json.NewDecoder(strings.NewReader(cfg0))
-	var cfgJson RouteConfigType
-	err := decCfg.Decode(&cfgJson)
-	if err == nil {
-		decCurrent := json.NewDecoder(strings.NewReader(cfg1))
-		var currentJson RouteConfigType
-		err := decCurrent.Decode(&currentJson)
-		if err == nil {
-			// Now we can compare two objects
-			if reflect.DeepEqual(cfgJson, currentJson) {
-				return true
+	return EqualJSON([]byte(cfg0), []byte(cfg1), IgnoreDefaults(ExplicitFields...), UnorderedArrays(SetLikeArrays...))
+}
+
+// SetLikeArrays are the keys of Caddy's configuration whose arrays are sets, so that the order of their elements
+// doesn't matter, such as hosts and paths of matchers.
+var SetLikeArrays = []string{"host", "path", "method", "ranges", "skip", "skip_certificates", "origins", "unhealthy_status"}
+
+// ExplicitFields are the keys of Caddy's configuration whose false differs from leaving them out, as Caddy
+// defaults them to true, such as "compression" of the reverse_proxy HTTP transport and "strict_sni_host" of servers.
+var ExplicitFields = []string{"compression", "enabled", "canonical_uris", "strict_sni_host", "install_trust", "smallest", "roll", "roll_gzip", "persist"}
+
+// EqualOption tweaks EqualJSON.
+type EqualOption func(*equalOptions)
+
+type equalOptions struct {
+	ignoreDefaults bool
+	explicit       map[string]bool
+	unordered      map[string]bool
+}
+
+// IgnoreDefaults makes EqualJSON treat fields set to null, false, 0 or "" the same as missing ones,
+// the way Caddy does when it loads its configuration. Empty objects and arrays are not defaults,
+// as Caddy tells them from missing ones, such as "tls": {} of a transport, which turns TLS on.
+//
+// Fields under the explicit keys, such as ExplicitFields, are told from missing ones even if they are
+// false or 0, as their defaults are something else.
+func IgnoreDefaults(explicit ...string) EqualOption {
+	return func(o *equalOptions) {
+		o.ignoreDefaults = true
+		if o.explicit == nil {
+			o.explicit = map[string]bool{}
+		}
+		for _, k := range explicit {
+			o.explicit[k] = true
+		}
+	}
+}
+
+// UnorderedArrays makes EqualJSON ignore the order of elements of the arrays under the given keys,
+// such as "host", anywhere in JSON. See SetLikeArrays.
+func UnorderedArrays(keys ...string) EqualOption {
+	return func(o *equalOptions) {
+		if o.unordered == nil {
+			o.unordered = map[string]bool{}
+		}
+		for _, k := range keys {
+			o.unordered[k] = true
+		}
+	}
+}
+
+// EqualJSON tells if JSON documents a and b, such as routes, servers or whole configurations, are semantically equal:
+// the order of object keys and the way numbers are written (1, 1.0, 1e0) don't matter.
+// See EqualOption for more allowances. Invalid JSON is equal to nothing.
+func EqualJSON(a, b []byte, opts ...EqualOption) bool {
+	var o equalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	va, err := decodeJSON(a)
+	if err != nil {
+		return false
+	}
+	vb, err := decodeJSON(b)
+	if err != nil {
+		return false
+	}
+	if o.ignoreDefaults {
+		va, vb = o.elideDefaults(va), o.elideDefaults(vb)
+	}
+	return o.equal(va, vb, false)
+}
+
+// decodeJSON decodes b keeping numbers as json.Number.
+func decodeJSON(b []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func (o equalOptions) equal(a, b any, unordered bool) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !o.equal(va, vb, o.unordered[k]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		if !unordered {
+			for i := range a {
+				if !o.equal(a[i], b[i], false) {
+					return false
+				}
 			}
+			return true
 		}
+		matched := make([]bool, len(b))
+	elements:
+		for i := range a {
+			for j := range b {
+				if !matched[j] && o.equal(a[i], b[j], false) {
+					matched[j] = true
+					continue elements
+				}
+			}
+			return false
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		return ok && numbersEqual(a, b)
+	}
+	return a == b
+}
+
+// numbersEqual compares JSON numbers exactly, no matter how they're written.
+func numbersEqual(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	ra, ok := new(big.Rat).SetString(string(a))
+	if !ok {
+		return false
+	}
+	rb, ok := new(big.Rat).SetString(string(b))
+	return ok && ra.Cmp(rb) == 0
+}
+
+// elideDefaults removes the object fields of v with default values, see isDefault, other than the explicit ones.
+// Objects and arrays stay even if everything in them is removed, as empty ones are not defaults.
+func (o equalOptions) elideDefaults(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, vv := range v {
+			vv = o.elideDefaults(vv)
+			if isDefault(vv) && (!o.explicit[k] || vv == nil) {
+				delete(v, k)
+			} else {
+				v[k] = vv
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = o.elideDefaults(v[i])
+		}
+	}
+	return v
+}
+
+func isDefault(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case json.Number:
+		return numbersEqual(v, "0")
 	}
+	// Empty objects and arrays are not defaults: "browse": {} turns browsing on,
+	// and a header matcher with [] wants the header to be present.
 	return false
 }
 
 // RouteConfigType is used to compare route configurations.
+//
+// Deprecated: RouteConfigsEqual compares whole route configurations with EqualJSON now.
 type RouteConfigType struct {
 	// TODO: It must eventually grow to fill the gaps
 	Id    string `json:"@id"`
@@ -123,3 +123,65 @@ func TestRouteConfigsEqual(t *testing.T) {
 		t.Errorf("Expected equal, found different:\n%v\n%v", cfg0, cfg1)
 	}
 }
+
+func TestRouteConfigsEqual_Differences(t *testing.T) {
+	base := `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`
+	tests := []struct {
+		name  string
+		cfg   string
+		equal bool
+	}{
+		{"hosts shuffled", `{"@id":"a","match":[{"host":["b.com","a.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`, true},
+		{"defaults", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080","max_requests":0}],"headers":null}],"terminal":false}`, true},
+		{"headers", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}],"headers":{"request":{"set":{"X-A":["b"]}}}}]}`, false},
+		{"load balancing", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}],"load_balancing":{"selection_policy":{"policy":"first"}}}]}`, false},
+		{"extra matcher", `{"@id":"a","match":[{"host":["a.com","b.com"],"method":["GET"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`, false},
+		{"empty path matcher", `{"@id":"a","match":[{"host":["a.com","b.com"],"path":[]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`, false},
+		{"header present", `{"@id":"a","match":[{"host":["a.com","b.com"],"header":{"X-A":[]}}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`, false},
+		{"transport tls", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","transport":{"protocol":"http","tls":{}},"upstreams":[{"dial":"localhost:8080"}]}]}`, false},
+		{"compression off", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","transport":{"protocol":"http","compression":false},"upstreams":[{"dial":"localhost:8080"}]}]}`, false},
+		{"upstreams shuffled", `{"@id":"a","match":[{"host":["a.com","b.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8081"},{"dial":"localhost:8080"}]}]}`, false},
+	}
+	for _, tt := range tests {
+		if got := RouteConfigsEqual(base, tt.cfg); got != tt.equal {
+			t.Errorf("%v: expected equal %v, got %v", tt.name, tt.equal, got)
+		}
+	}
+}
+
+func TestEqualJSON(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		opts  []EqualOption
+		equal bool
+	}{
+		{`{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, nil, true},
+		{`{"a":1}`, `{"a":1.0}`, nil, true},
+		{`{"a":100}`, `{"a":1e2}`, nil, true},
+		{`{"a":0.1}`, `{"a":0.10000000000000001}`, nil, false},
+		{`{"a":1}`, `{"a":"1"}`, nil, false},
+		{`{"a":1,"b":null}`, `{"a":1}`, nil, false},
+		{`{"a":1,"b":null}`, `{"a":1}`, []EqualOption{IgnoreDefaults()}, true},
+		{`{"a":{"b":{"d":0,"e":""}}}`, `{"a":{"b":{}}}`, []EqualOption{IgnoreDefaults()}, true},
+		{`{"a":{"b":{"c":[]}}}`, `{"a":{"b":{}}}`, []EqualOption{IgnoreDefaults()}, false},
+		{`{"a":{"b":{}}}`, `{"a":{}}`, []EqualOption{IgnoreDefaults()}, false},
+		{`{"handler":"file_server","browse":{}}`, `{"handler":"file_server"}`, []EqualOption{IgnoreDefaults()}, false},
+		{`{"transport":{"protocol":"http","compression":false}}`, `{"transport":{"protocol":"http"}}`, []EqualOption{IgnoreDefaults()}, true},
+		{`{"transport":{"protocol":"http","compression":false}}`, `{"transport":{"protocol":"http"}}`, []EqualOption{IgnoreDefaults(ExplicitFields...)}, false},
+		{`{"strict_sni_host":false,"compression":null}`, `{}`, []EqualOption{IgnoreDefaults(ExplicitFields...)}, false},
+		{`{"compression":null,"a":0}`, `{}`, []EqualOption{IgnoreDefaults(ExplicitFields...)}, true},
+		{`[0]`, `[]`, []EqualOption{IgnoreDefaults()}, false},
+		{`{"host":["a","b","a"]}`, `{"host":["b","a","a"]}`, nil, false},
+		{`{"host":["a","b","a"]}`, `{"host":["b","a","a"]}`, []EqualOption{UnorderedArrays("host")}, true},
+		{`{"host":["a","b","b"]}`, `{"host":["b","a","a"]}`, []EqualOption{UnorderedArrays("host")}, false},
+		{`{"x":{"host":[{"a":1},{"b":2}]}}`, `{"x":{"host":[{"b":2},{"a":1}]}}`, []EqualOption{UnorderedArrays("host")}, true},
+		{`{"path":[["a","b"]]}`, `{"path":[["b","a"]]}`, []EqualOption{UnorderedArrays("path")}, false},
+		{`{`, `{`, nil, false},
+		{`{} {}`, `{}`, nil, false},
+	}
+	for _, tt := range tests {
+		if got := EqualJSON([]byte(tt.a), []byte(tt.b), tt.opts...); got != tt.equal {
+			t.Errorf("EqualJSON(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.equal)
+		}
+	}
+}
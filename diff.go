@@ -0,0 +1,232 @@
+package caddycfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChangeKind tells what kind of a Change it is.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a value that is only in the second document.
+	ChangeAdded ChangeKind = iota + 1
+	// ChangeRemoved is a value that is only in the first document.
+	ChangeRemoved
+	// ChangeModified is a value that differs between the documents.
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change is a single difference between two JSON documents found by Diff.
+type Change struct {
+	Kind ChangeKind
+	// Path is a JSON Pointer (RFC 6901) of the value, such as "/apps/http/servers/myserver/routes/0".
+	// Removed values are addressed in the first document, while added and modified ones are addressed
+	// in the second one, as array elements may have shifted.
+	Path string
+	// Old is the value in the first document, nil for ChangeAdded.
+	Old json.RawMessage
+	// New is the value in the second document, nil for ChangeRemoved.
+	New json.RawMessage
+}
+
+// String renders the change on a single line, which is good for logs:
+//
+//	~ /handle/0/upstreams/0/dial: "localhost:8080" -> "localhost:9000"
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %v: %s", path, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %v: %s", path, c.Old)
+	}
+	return fmt.Sprintf("~ %v: %s -> %s", path, c.Old, c.New)
+}
+
+// Diff returns the differences between JSON documents a and b, such as two configurations or routes,
+// in document order. Equal documents have no differences.
+//
+// Values are compared the way EqualJSON compares them, with the same options. Arrays are matched element by element
+// along their longest common subsequence, so that a route inserted in the middle of routes shows up as a single
+// addition rather than changes to all the routes after it. Arrays from UnorderedArrays are compared as a whole.
+//
+// If either document is not valid JSON, the difference is a single modification of the whole document.
+func Diff(a, b []byte, opts ...EqualOption) []Change {
+	var o equalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	va, errA := decodeJSON(a)
+	vb, errB := decodeJSON(b)
+	if errA != nil || errB != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []Change{{Kind: ChangeModified, Old: a, New: b}}
+	}
+	if o.ignoreDefaults {
+		va, vb = o.elideDefaults(va), o.elideDefaults(vb)
+	}
+	var changes []Change
+	o.diff(&changes, "", va, vb, false)
+	return changes
+}
+
+func (o equalOptions) diff(changes *[]Change, path string, a, b any, unordered bool) {
+	if o.equal(a, b, unordered) {
+		return
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			o.diffObjects(changes, path, a, b)
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok && !unordered {
+			o.diffArrays(changes, path, a, b)
+			return
+		}
+	}
+	*changes = append(*changes, Change{Kind: ChangeModified, Path: path, Old: encodeJSON(a), New: encodeJSON(b)})
+}
+
+func (o equalOptions) diffObjects(changes *[]Change, path string, a, b map[string]any) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inB:
+			*changes = append(*changes, Change{Kind: ChangeRemoved, Path: p, Old: encodeJSON(va)})
+		case !inA:
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: p, New: encodeJSON(vb)})
+		default:
+			o.diff(changes, p, va, vb, o.unordered[k])
+		}
+	}
+}
+
+func (o equalOptions) diffArrays(changes *[]Change, path string, a, b []any) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case o.equal(a[i], b[j], false):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Between common elements, removed and added elements at the same place are modifications.
+	var removed, added []int
+	flush := func() {
+		for k := range removed {
+			if k < len(added) {
+				o.diff(changes, fmt.Sprintf("%v/%d", path, added[k]), a[removed[k]], b[added[k]], false)
+			} else {
+				*changes = append(*changes, Change{Kind: ChangeRemoved, Path: fmt.Sprintf("%v/%d", path, removed[k]), Old: encodeJSON(a[removed[k]])})
+			}
+		}
+		for k := len(removed); k < len(added); k++ {
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: fmt.Sprintf("%v/%d", path, added[k]), New: encodeJSON(b[added[k]])})
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && o.equal(a[i], b[j], false):
+			flush()
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+}
+
+// escapePointer escapes a JSON Pointer reference token.
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func encodeJSON(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// FormatDiff renders changes found by Diff as unified text with a hunk per change
+// and indented values, which is good for reviewing planned changes:
+//
+//	@@ /handle/0/upstreams/0/dial @@
+//	-"localhost:8080"
+//	+"localhost:9000"
+func FormatDiff(changes []Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&b, "@@ %v @@\n", c.Path)
+		if c.Kind != ChangeAdded {
+			writeLines(&b, "-", c.Old)
+		}
+		if c.Kind != ChangeRemoved {
+			writeLines(&b, "+", c.New)
+		}
+	}
+	return b.String()
+}
+
+func writeLines(b *strings.Builder, prefix string, value json.RawMessage) {
+	var indented bytes.Buffer
+	if json.Indent(&indented, value, "", "  ") != nil {
+		indented.Reset()
+		indented.Write(value)
+	}
+	for _, line := range strings.Split(indented.String(), "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+}
@@ -0,0 +1,116 @@
+package caddycfg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := `{
+		"admin": {"listen": "localhost:2019"},
+		"apps": {"http": {"servers": {"my/server": {
+			"listen": [":443"],
+			"routes": [
+				{"@id": "a", "match": [{"host": ["a.com", "b.com"]}], "handle": [{"handler": "reverse_proxy", "upstreams": [{"dial": "localhost:8080"}]}]},
+				{"@id": "b", "handle": [{"handler": "static_response", "body": "b"}]},
+				{"@id": "c", "handle": [{"handler": "static_response", "body": "c"}]}
+			]
+		}}}}
+	}`
+	b := `{
+		"apps": {"http": {"servers": {"my/server": {
+			"listen": [":443"],
+			"routes": [
+				{"@id": "a", "match": [{"host": ["b.com", "a.com"]}], "handle": [{"handler": "reverse_proxy", "upstreams": [{"dial": "localhost:9000"}]}], "terminal": true},
+				{"@id": "new", "handle": [{"handler": "static_response", "body": "new"}]},
+				{"@id": "b", "handle": [{"handler": "static_response", "body": "b"}]}
+			],
+			"read_timeout": 1e9
+		}}}}
+	}`
+	changes := Diff([]byte(a), []byte(b), UnorderedArrays(SetLikeArrays...))
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		`- /admin: {"listen":"localhost:2019"}`,
+		`+ /apps/http/servers/my~1server/read_timeout: 1e9`,
+		`~ /apps/http/servers/my~1server/routes/0/handle/0/upstreams/0/dial: "localhost:8080" -> "localhost:9000"`,
+		`+ /apps/http/servers/my~1server/routes/0/terminal: true`,
+		`+ /apps/http/servers/my~1server/routes/1: {"@id":"new","handle":[{"body":"new","handler":"static_response"}]}`,
+		`- /apps/http/servers/my~1server/routes/2: {"@id":"c","handle":[{"body":"c","handler":"static_response"}]}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff error, want:\n%q\ngot:\n%q", want, got)
+	}
+
+	if changes := Diff([]byte(a), []byte(a)); changes != nil {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+	if changes := Diff([]byte(`{"a":[1,2,3]}`), []byte(`{"a":[3,2,1]}`)); len(changes) != 4 {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+	if changes := Diff([]byte(`{"a":[1,2,3]}`), []byte(`{"a":[3,2]}`), UnorderedArrays("a")); len(changes) != 1 || changes[0].Path != "/a" {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+	if changes := Diff([]byte(`{"a":1}`), []byte(`{"a":1,"b":null}`), IgnoreDefaults()); changes != nil {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+	changes = Diff([]byte(`{`), []byte(`1`))
+	if len(changes) != 1 || changes[0].String() != `~ (root): { -> 1` {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+}
+
+func ExampleFormatDiff() {
+	changes := Diff(
+		[]byte(`{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]}`),
+		[]byte(`{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:9000"}]}],"match":[{"host":["example.com"]}]}`),
+	)
+	fmt.Print(FormatDiff(changes))
+	// Output:
+	// @@ /handle/0/upstreams/0/dial @@
+	// -"localhost:8080"
+	// +"localhost:9000"
+	// @@ /match @@
+	// +[
+	// +  {
+	// +    "host": [
+	// +      "example.com"
+	// +    ]
+	// +  }
+	// +]
+}
+
+func TestCaddyCfg_AddRoute_ReportChanges(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	var reported []string
+	report := ReportChanges(func(changes []Change) {
+		for _, c := range changes {
+			reported = append(reported, c.String())
+		}
+	})
+	steps := []struct {
+		port int
+		opts []RouteOption
+		want []string
+	}{
+		{8080, nil, nil},
+		{8080, nil, nil},
+		{9000, nil, []string{`~ /handle/0/upstreams/0/dial: "localhost:8080" -> "localhost:9000"`}},
+		{9001, []RouteOption{AtIndex(0)}, []string{`~ /handle/0/upstreams/0/dial: "localhost:9000" -> "localhost:9001"`}},
+	}
+	for i, s := range steps {
+		reported = nil
+		err := caddyCfg.AddRoute("myserver", "example.com", ReverseProxyCaddyRouteConf(s.port, []string{"example.com"}, "/*"), append(s.opts, report)...)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !reflect.DeepEqual(reported, s.want) {
+			t.Errorf("Step %d: want %q, got %q", i, s.want, reported)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package caddycfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxConflictRetries limits how many times an operation is attempted when it keeps losing
+// races to concurrent configuration changes.
+const maxConflictRetries = 10
+
+// IfMatch returns a copy of caddyCfg that sends etag in "If-Match" header along with every write
+// (Upload, DeleteById, AddRoute and so on), so that Caddy refuses the write if the configuration
+// has changed since etag was obtained with ConfigWithETag or ConfigByIdWithETag. Refused writes
+// fail with *APIError matching errors.Is(err, ErrConflict).
+//
+// Caddy's ETag identifies the config path it was obtained for along with the hash of the config under it,
+// so an ETag of the whole configuration protects against any change, while an ETag of a route only
+// protects against changes to that route.
+//
+// Upload with an ETag replaces the configuration through "/config/" instead of "/load",
+// since only the former honours "If-Match".
+//
+// An empty etag turns the check off.
+func (caddyCfg *CaddyCfg) IfMatch(etag string) *CaddyCfg {
+	c := *caddyCfg
+	c.ifMatch = etag
+	return &c
+}
+
+// ConfigWithETag is like ConfigContext, but also returns Caddy's ETag of the configuration to be used with IfMatch.
+func (caddyCfg *CaddyCfg) ConfigWithETag(ctx context.Context) (string, string, error) {
+	b, etag, err := caddyCfg.send(ctx, caddyCfg.httpClient, http.MethodGet, JoinURLPath(caddyCfg.configURL.String(), "config")+"/", nil)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), etag, nil
+}
+
+// ConfigByIdWithETag is like ConfigByIdContext, but also returns Caddy's ETag of the configuration section
+// to be used with IfMatch.
+func (caddyCfg *CaddyCfg) ConfigByIdWithETag(ctx context.Context, id string) (string, string, error) {
+	b, etag, err := caddyCfg.send(ctx, caddyCfg.httpClient, http.MethodGet, JoinURLPath(caddyCfg.configURL.String(), "id", url.PathEscape(id)), nil)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), etag, nil
+}
+
+// ErrNoChange can be returned by the function passed to Modify to leave the configuration as is.
+var ErrNoChange = errors.New("no change")
+
+// Modify performs read-modify-write of the configuration under path, such as "apps/http/servers/myserver/routes".
+// An empty path stands for the whole configuration.
+//
+// modify receives current JSON under path ("null" if there's nothing) and returns the JSON to replace it with,
+// or ErrNoChange to leave it as is. The write is guarded with the ETag of what modify has seen, and in case
+// the configuration has changed in the meantime, the whole read-modify-write is repeated, so modify may be called
+// several times. Any other error returned by modify stops Modify and gets returned as is.
+//
+// When IfMatch is in effect, its ETag guards the write instead, and a conflict is returned without retrying.
+func (caddyCfg *CaddyCfg) Modify(ctx context.Context, path string, modify func(current json.RawMessage) (json.RawMessage, error)) error {
+	configURL := JoinURLPath(caddyCfg.configURL.String(), "config", strings.Trim(path, "/"))
+	if strings.Trim(path, "/") == "" {
+		// Caddy's "/config" redirects to "/config/", and redirects turn writes into GET.
+		configURL += "/"
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = caddyCfg.modify(ctx, configURL, modify)
+		if errors.Is(err, ErrConflict) && caddyCfg.ifMatch == "" && attempt < maxConflictRetries {
+			continue
+		}
+		break
+	}
+	if errors.Is(err, ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// modify makes a single read-modify-write attempt for Modify.
+func (caddyCfg *CaddyCfg) modify(ctx context.Context, configURL string, modify func(current json.RawMessage) (json.RawMessage, error)) error {
+	current, etag, err := caddyCfg.send(ctx, caddyCfg.httpClient, http.MethodGet, configURL, nil)
+	if err != nil {
+		return err
+	}
+	current = bytes.TrimSuffix(current, []byte("\n"))
+	next, err := modify(current)
+	if err != nil {
+		return err
+	}
+	// PATCH replaces existing values only, while POST sets missing ones.
+	method := http.MethodPatch
+	if string(current) == "null" {
+		method = http.MethodPost
+	}
+	target := caddyCfg
+	if target.ifMatch == "" {
+		target = caddyCfg.IfMatch(etag)
+	}
+	_, _, err = target.send(ctx, caddyCfg.httpClient, method, configURL, bytes.NewReader(next))
+	return err
+}
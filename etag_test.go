@@ -0,0 +1,127 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCaddyCfg_IfMatch(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	_, etag, err := caddyCfg.ConfigWithETag(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.HasPrefix(etag, `"/config/ `) {
+		t.Fatalf("Expected ETag of the whole config, got %v", etag)
+	}
+	if err := caddyCfg.IfMatch(etag).AddRoute("myserver", "a.example.com", ReverseProxyCaddyRouteConf(8080, []string{"a.example.com"}, "/*")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// etag is stale now
+	err = caddyCfg.IfMatch(etag).AddRoute("myserver", "b.example.com", ReverseProxyCaddyRouteConf(8081, []string{"b.example.com"}, "/*"))
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+	if err := caddyCfg.IfMatch(etag).DeleteById("a.example.com"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+
+	route, etag, err := caddyCfg.ConfigByIdWithETag(ctx, "a.example.com")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.HasPrefix(route, `{"@id":"a.example.com"`) || !strings.HasPrefix(etag, `"/config/apps/http/servers/myserver/routes/0 `) {
+		t.Errorf("Unexpected route %v with ETag %v", route, etag)
+	}
+	// Unrelated changes don't invalidate the ETag of the route.
+	if err := caddyCfg.AddRoute("myserver", "c.example.com", ReverseProxyCaddyRouteConf(8082, []string{"c.example.com"}, "/*")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.IfMatch(etag).DeleteById("a.example.com"); err != nil {
+		t.Errorf("Expected route to be deleted, got %v", err)
+	}
+
+	config, etag, err := caddyCfg.ConfigWithETag(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	admin.served()
+	if err := caddyCfg.IfMatch(etag).Upload(config); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := caddyCfg.DeleteById("c.example.com"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.IfMatch(etag).Upload(config); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+	if served := strings.Join(admin.served(), ","); served != "POST /config/,DELETE /id/c.example.com,POST /config/" {
+		t.Errorf("Expected upload through /config/, got %v", served)
+	}
+}
+
+func TestCaddyCfg_Modify(t *testing.T) {
+	admin := newFakeAdmin(t, `{"apps":{"http":{"servers":{"myserver":{"listen":[":443"]}}}}}`)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := caddyCfg.Modify(ctx, "apps/http/servers/myserver/listen", func(current json.RawMessage) (json.RawMessage, error) {
+				var listen []string
+				if err := json.Unmarshal(current, &listen); err != nil {
+					return nil, err
+				}
+				return json.Marshal(append(listen, fmt.Sprintf(":%d", 8000+i)))
+			})
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	var listen []string
+	err := caddyCfg.Modify(ctx, "/apps/http/servers/myserver/listen/", func(current json.RawMessage) (json.RawMessage, error) {
+		if err := json.Unmarshal(current, &listen); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoChange
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(listen) != 6 {
+		t.Errorf("Expected every concurrent modification to be applied, got %v", listen)
+	}
+
+	err = caddyCfg.Modify(ctx, "apps/http/servers/myserver/routes", func(current json.RawMessage) (json.RawMessage, error) {
+		if string(current) != "null" {
+			t.Errorf("Expected null for missing routes, got %s", current)
+		}
+		return json.RawMessage(`[]`), nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	errFailed := errors.New("failed")
+	err = caddyCfg.Modify(ctx, "", func(current json.RawMessage) (json.RawMessage, error) {
+		return nil, errFailed
+	})
+	if err != errFailed {
+		t.Errorf("Expected error of modify to be returned, got %v", err)
+	}
+	if c := admin.config(); !strings.HasSuffix(c, `"routes":[]}}}}}`) {
+		t.Errorf("Expected empty routes to be created, got:\n%v", c)
+	}
+}
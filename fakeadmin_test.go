@@ -0,0 +1,308 @@
+package caddycfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAdmin is an in-memory imitation of Caddy's admin API, good enough to exercise CaddyCfg
+// without running caddy. It serves "/load", "/config/..." and "/id/..." with the same
+// GET, POST, PUT, PATCH and DELETE semantics Caddy has, including ETags and "If-Match".
+type fakeAdmin struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	raw      map[string]any // {"config": <config>}, the way Caddy keeps it
+	requests []string       // "<method> <path>" of every request served
+
+	// reject, when set, is called with every new config, and a non-nil error
+	// refuses it with 400 Bad Request, the way Caddy refuses configs that fail to load.
+	reject func(config any) error
+}
+
+// newFakeAdmin starts a fakeAdmin with config as its initial configuration.
+func newFakeAdmin(t *testing.T, config string) *fakeAdmin {
+	var cfg any
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	f := &fakeAdmin{raw: map[string]any{"config": cfg}}
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// config returns current configuration as compact JSON.
+func (f *fakeAdmin) config() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, _ := json.Marshal(f.raw["config"])
+	return string(b)
+}
+
+// served returns requests served so far and forgets them.
+func (f *fakeAdmin) served() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.requests
+	f.requests = nil
+	return r
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, err)
+		return
+	}
+	p := r.URL.Path
+	switch {
+	case p == "/load":
+		f.change(w, http.MethodPost, "/config", body)
+		return
+	case strings.HasPrefix(p, "/id/"):
+		id, rest, _ := strings.Cut(strings.TrimPrefix(p, "/id/"), "/")
+		expanded, ok := f.index()[id]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, fmt.Errorf("unknown object ID '%s'", id))
+			return
+		}
+		p = expanded
+		if rest != "" {
+			p += "/" + rest
+		}
+	case p == "/config" || strings.HasPrefix(p, "/config/"):
+	default:
+		writeFakeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+	if r.Method == http.MethodGet {
+		b, err := f.read(p)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Trailer", "Etag")
+		_, _ = w.Write(b)
+		w.Header().Set("Etag", fakeETag(p, b))
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		etagPath, _, _ := strings.Cut(strings.Trim(ifMatch, `"`), " ")
+		b, err := f.read(etagPath)
+		if err != nil || fakeETag(etagPath, b) != ifMatch {
+			writeFakeError(w, http.StatusPreconditionFailed, fmt.Errorf("If-Match header did not match current config hash"))
+			return
+		}
+	}
+	f.change(w, r.Method, p, body)
+}
+
+// read returns JSON under p the way Caddy encodes it.
+func (f *fakeAdmin) read(p string) ([]byte, error) {
+	v, err := f.access(http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = json.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+// fakeETag makes an ETag the same way Caddy does.
+func fakeETag(p string, b []byte) string {
+	h := fnv.New32a()
+	_, _ = h.Write(b)
+	return fmt.Sprintf(`"%s %x"`, p, h.Sum(nil))
+}
+
+// change applies a write and rolls it back if it fails or the new config gets rejected.
+func (f *fakeAdmin) change(w http.ResponseWriter, method, p string, body []byte) {
+	backup := f.snapshot()
+	if _, err := f.access(method, p, body); err != nil {
+		f.raw = backup
+		writeFakeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if f.reject != nil {
+		if err := f.reject(f.raw["config"]); err != nil {
+			f.raw = backup
+			writeFakeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+}
+
+func (f *fakeAdmin) snapshot() map[string]any {
+	b, _ := json.Marshal(f.raw)
+	var raw map[string]any
+	_ = json.Unmarshal(b, &raw)
+	return raw
+}
+
+// index maps every "@id" to the config path of the object carrying it.
+func (f *fakeAdmin) index() map[string]string {
+	index := map[string]string{}
+	var walk func(v any, p string)
+	walk = func(v any, p string) {
+		switch v := v.(type) {
+		case map[string]any:
+			if id, ok := v["@id"].(string); ok {
+				index[id] = p
+			}
+			for k, vv := range v {
+				walk(vv, p+"/"+k)
+			}
+		case []any:
+			for i, vv := range v {
+				walk(vv, p+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	walk(f.raw["config"], "/config")
+	return index
+}
+
+// access is a port of Caddy's unsyncedConfigAccess.
+func (f *fakeAdmin) access(method, p string, body []byte) (any, error) {
+	var val any
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &val); err != nil {
+			return nil, fmt.Errorf("decoding request body: %v", err)
+		}
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	ellipses := parts[len(parts)-1] == "..."
+	if ellipses {
+		parts = parts[:len(parts)-1]
+	}
+	appendVal := func(arr []any) ([]any, error) {
+		if !ellipses {
+			return append(arr, val), nil
+		}
+		valArray, ok := val.([]any)
+		if !ok {
+			return nil, fmt.Errorf("final element is not an array")
+		}
+		return append(arr, valArray...), nil
+	}
+	var ptr any = f.raw
+	for i, part := range parts {
+		switch v := ptr.(type) {
+		case map[string]any:
+			if arr, ok := v[part].([]any); ok && i == len(parts)-2 {
+				idx := 0
+				if method != http.MethodPost {
+					var err error
+					idx, err = strconv.Atoi(parts[len(parts)-1])
+					if err != nil {
+						return nil, fmt.Errorf("[%s] invalid array index '%s': %v", p, parts[len(parts)-1], err)
+					}
+					if idx < 0 || idx >= len(arr) {
+						return nil, fmt.Errorf("[%s] array index out of bounds: %d", p, idx)
+					}
+				}
+				switch method {
+				case http.MethodGet:
+					return arr[idx], nil
+				case http.MethodPost:
+					a, err := appendVal(arr)
+					if err != nil {
+						return nil, err
+					}
+					v[part] = a
+				case http.MethodPut:
+					arr = append(arr, nil)
+					copy(arr[idx+1:], arr[idx:])
+					arr[idx] = val
+					v[part] = arr
+				case http.MethodPatch:
+					arr[idx] = val
+				case http.MethodDelete:
+					v[part] = append(arr[:idx], arr[idx+1:]...)
+				default:
+					return nil, fmt.Errorf("unrecognized method %s", method)
+				}
+				return nil, nil
+			}
+			if i == len(parts)-1 {
+				switch method {
+				case http.MethodGet:
+					return v[part], nil
+				case http.MethodPost:
+					if arr, ok := v[part].([]any); ok {
+						a, err := appendVal(arr)
+						if err != nil {
+							return nil, err
+						}
+						v[part] = a
+					} else {
+						v[part] = val
+					}
+				case http.MethodPut:
+					if _, ok := v[part]; ok {
+						return nil, fmt.Errorf("[%s] key already exists: %s", p, part)
+					}
+					v[part] = val
+				case http.MethodPatch:
+					if _, ok := v[part]; !ok {
+						return nil, fmt.Errorf("[%s] key does not exist: %s", p, part)
+					}
+					v[part] = val
+				case http.MethodDelete:
+					delete(v, part)
+				default:
+					return nil, fmt.Errorf("unrecognized method %s", method)
+				}
+				return nil, nil
+			}
+			if v[part] == nil && method == http.MethodPut {
+				v[part] = map[string]any{}
+			}
+			ptr = v[part]
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("[/%s] invalid array index '%s': %v", strings.Join(parts[:i+1], "/"), part, err)
+			}
+			if idx < 0 || idx >= len(v) {
+				return nil, fmt.Errorf("[/%s] array index out of bounds: %s", strings.Join(parts[:i+1], "/"), part)
+			}
+			if i == len(parts)-1 {
+				switch method {
+				case http.MethodGet:
+					return v[idx], nil
+				case http.MethodPatch:
+					v[idx] = val
+					return nil, nil
+				}
+				return nil, fmt.Errorf("unrecognized method %s", method)
+			}
+			ptr = v[idx]
+		default:
+			return nil, fmt.Errorf("invalid traversal path at: %s", strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return nil, nil
+}
+
+func writeFakeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,130 @@
+package caddycfg
+
+import (
+	"encoding/json"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/fileserver"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/rewrite"
+)
+
+// FileServerOption tweaks the route made by FileServerRouteConf.
+type FileServerOption func(*fileServerOptions)
+
+type fileServerOptions struct {
+	tryFiles      []string
+	indexNames    []string
+	hide          []string
+	browse        bool
+	precompressed []string
+	passThru      bool
+}
+
+// TryFiles makes the route serve the first of files that exists, rather than the requested one.
+// Files are paths within the root, which may have placeholders. For a single page app, fall back to its index
+// for paths that are not files:
+//
+//	TryFiles("{http.request.uri.path}", "/index.html")
+func TryFiles(files ...string) FileServerOption {
+	return func(o *fileServerOptions) {
+		o.tryFiles = files
+	}
+}
+
+// IndexFiles sets the files served for requests of directories. Caddy's default is "index.html" and "index.txt".
+func IndexFiles(names ...string) FileServerOption {
+	return func(o *fileServerOptions) {
+		o.indexNames = names
+	}
+}
+
+// HideFiles keeps files matching any of patterns from being served, such as ".git" or "*.map".
+// Patterns without a path separator match files of that name in any directory.
+func HideFiles(patterns ...string) FileServerOption {
+	return func(o *fileServerOptions) {
+		o.hide = append(o.hide, patterns...)
+	}
+}
+
+// Browse makes the route list the files of directories that have no index file.
+func Browse() FileServerOption {
+	return func(o *fileServerOptions) {
+		o.browse = true
+	}
+}
+
+// Precompressed makes the route serve precompressed files next to the requested ones, such as "app.js.br",
+// to clients that accept them. Encodings are "br", "zstd" and "gzip", in the order of preference.
+func Precompressed(encodings ...string) FileServerOption {
+	return func(o *fileServerOptions) {
+		o.precompressed = encodings
+	}
+}
+
+// PassThru makes the route pass requests of files that don't exist to the routes after it instead of
+// responding with 404 Not Found.
+func PassThru() FileServerOption {
+	return func(o *fileServerOptions) {
+		o.passThru = true
+	}
+}
+
+// FileServerRouteConf generates a "routes" element configuration structure serving static files from root,
+// such as the build directory of a single page app, for requests to any of hosts, or to all hosts if there are none:
+//
+//	route := FileServerRouteConf("/srv/app/dist", []string{"app.example.com"},
+//		TryFiles("{http.request.uri.path}", "/index.html"), Precompressed("br", "gzip"))
+//
+// The route sets the "root" variable with a "vars" handler, which the "file_server" handler and the file matcher of
+// TryFiles use.
+func FileServerRouteConf(root string, hosts []string, opts ...FileServerOption) *caddyhttp.Route {
+	var o fileServerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	route := caddyhttp.Route{
+		HandlersRaw: []json.RawMessage{
+			caddyconfig.JSONModuleObject(caddyhttp.VarsMiddleware{"root": root}, "handler", "vars", nil),
+		},
+	}
+	if len(hosts) > 0 {
+		route.MatcherSetsRaw = []caddy.ModuleMap{
+			{"host": caddyconfig.JSON(caddyhttp.MatchHost(hosts), nil)},
+		}
+	}
+	if len(o.tryFiles) > 0 {
+		// The rewrite needs a matcher of its own, hence the subroute. Its routes are followed
+		// by the handlers of the route, so "file_server" serves the rewritten request.
+		tryFiles := caddyhttp.Route{
+			MatcherSetsRaw: []caddy.ModuleMap{
+				{"file": caddyconfig.JSON(fileserver.MatchFile{TryFiles: o.tryFiles}, nil)},
+			},
+			HandlersRaw: []json.RawMessage{
+				caddyconfig.JSONModuleObject(rewrite.Rewrite{URI: "{http.matchers.file.relative}"}, "handler", "rewrite", nil),
+			},
+		}
+		route.HandlersRaw = append(route.HandlersRaw,
+			caddyconfig.JSONModuleObject(caddyhttp.Subroute{Routes: caddyhttp.RouteList{tryFiles}}, "handler", "subroute", nil))
+	}
+
+	fs := fileserver.FileServer{
+		Hide:               o.hide,
+		IndexNames:         o.indexNames,
+		PassThru:           o.passThru,
+		PrecompressedOrder: o.precompressed,
+	}
+	if o.browse {
+		fs.Browse = &fileserver.Browse{}
+	}
+	for _, encoding := range o.precompressed {
+		if fs.PrecompressedRaw == nil {
+			fs.PrecompressedRaw = caddy.ModuleMap{}
+		}
+		fs.PrecompressedRaw[encoding] = json.RawMessage("{}")
+	}
+	route.HandlersRaw = append(route.HandlersRaw, caddyconfig.JSONModuleObject(fs, "handler", "file_server", nil))
+	return &route
+}
@@ -0,0 +1,66 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestFileServerRouteConf(t *testing.T) {
+	route := FileServerRouteConf("/srv/app/dist", []string{"app.example.com"},
+		TryFiles("{http.request.uri.path}", "/index.html"),
+		IndexFiles("index.html"),
+		HideFiles(".git"), HideFiles("*.map"),
+		Browse(),
+		Precompressed("br", "gzip"),
+		PassThru(),
+	)
+	b, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{
+		"match": [{"host": ["app.example.com"]}],
+		"handle": [
+			{"handler": "vars", "root": "/srv/app/dist"},
+			{"handler": "subroute", "routes": [{
+				"match": [{"file": {"try_files": ["{http.request.uri.path}", "/index.html"]}}],
+				"handle": [{"handler": "rewrite", "uri": "{http.matchers.file.relative}"}]
+			}]},
+			{
+				"handler": "file_server",
+				"hide": [".git", "*.map"],
+				"index_names": ["index.html"],
+				"browse": {},
+				"pass_thru": true,
+				"precompressed": {"br": {}, "gzip": {}},
+				"precompressed_order": ["br", "gzip"]
+			}
+		]
+	}`
+	if !EqualJSON(b, []byte(want)) {
+		t.Errorf("Unexpected route %s", b)
+	}
+
+	b, err = json.Marshal(FileServerRouteConf("/srv/www", nil))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `{"handle":[{"handler":"vars","root":"/srv/www"},{"handler":"file_server"}]}`; !EqualJSON(b, []byte(want)) {
+		t.Errorf("Want %v, got %s", want, b)
+	}
+
+	// The route keeps its "vars" handler apart from the one with the metadata of caddycfg.
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	if err := caddyCfg.AddRoute("myserver", "app", route, Owner("me"), VerifyRoute()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var served Route
+	if err := caddyCfg.Get(context.Background(), &served, "apps", "http", "servers", "myserver", "routes", "0"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(served.Handle) != 4 || served.Handle[1].HandlerName() != "vars" || served.Handle[3].HandlerName() != "file_server" {
+		t.Errorf("Unexpected handlers %+v", served.Handle)
+	}
+}
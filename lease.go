@@ -0,0 +1,64 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// SweepExpiredRoutes deletes the routes of all the servers whose lease attached with Lease has lapsed,
+// and returns the deletions made. Routes without a lease are never touched.
+//
+// Any app may sweep, as leases are kept in Caddy's configuration. The sweep is safe to run concurrently
+// with heartbeats: all the routes are deleted with a single write, guarded by the ETag of the servers
+// unless IfMatch is in effect, so a route renewed in the meantime is not deleted.
+func (caddyCfg *CaddyCfg) SweepExpiredRoutes(ctx context.Context) (Plan, error) {
+	now := time.Now()
+	return caddyCfg.pruneRoutes(ctx, func(route json.RawMessage) (string, bool) {
+		expires := routeMetaOf(route).Expires
+		if expires == nil || expires.After(now) {
+			return "", false
+		}
+		var f IDField
+		if json.Unmarshal(route, &f) != nil || f.Id == "" {
+			return "", false
+		}
+		return f.Id, true
+	})
+}
+
+// Sweeper calls SweepExpiredRoutes immediately and then every interval until ctx is done with Refresh,
+// so failed sweeps are retried with backoff. Results of every sweep are passed to report, unless it's nil.
+//
+// This will likely run in a separate Goroutine.
+func (caddyCfg *CaddyCfg) Sweeper(ctx context.Context, interval time.Duration, report func(Plan, error)) {
+	_ = Refresh(ctx, interval, func(ctx context.Context) error {
+		plan, err := caddyCfg.SweepExpiredRoutes(ctx)
+		if report != nil && ctx.Err() == nil {
+			report(plan, err)
+		}
+		return err
+	})
+}
+
+// Heartbeat keeps the route added with a lease of ttl until ctx is done, renewing the lease every ttl/3
+// with AddRouteContext and Refresh, so that a route of a crashed app gets deleted by SweepExpiredRoutes
+// soon after the app stops renewing it. opts are passed to AddRouteContext along with Lease(ttl).
+//
+// Failed renewals are passed to onError, unless it's nil, and retried sooner than ttl/3 to keep the lease.
+// The lease isn't released once ctx is done, so the route stays until it expires, unless it's deleted with DeleteById.
+// Every renewal makes Caddy reload its configuration, see Lease.
+//
+// This will likely run in a separate Goroutine.
+func (caddyCfg *CaddyCfg) Heartbeat(ctx context.Context, serverKey string, routeId string, routeConfig *caddyhttp.Route, ttl time.Duration, onError func(error), opts ...RouteOption) {
+	opts = append(opts[:len(opts):len(opts)], Lease(ttl))
+	_ = Refresh(ctx, ttl/3, func(ctx context.Context) error {
+		err := caddyCfg.AddRouteContext(ctx, serverKey, routeId, routeConfig, opts...)
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		return err
+	}, RefreshBackoff(ttl/12, ttl/3))
+}
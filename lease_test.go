@@ -0,0 +1,106 @@
+package caddycfg
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaddyCfg_SweepExpiredRoutes(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	add := func(id string, opts ...RouteOption) {
+		t.Helper()
+		if err := caddyCfg.AddRoute("myserver", id, ReverseProxyCaddyRouteConf(8080, []string{id}, "/*"), opts...); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	add("expired", Lease(-time.Second), Owner("app"))
+	add("forever")
+	add("alive", Lease(time.Hour))
+
+	plan, err := caddyCfg.SweepExpiredRoutes(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `delete route 'expired' from server 'myserver' at 0`; plan.String() != want {
+		t.Errorf("Plan error, want:\n%v\ngot:\n%v", want, plan)
+	}
+	if ids := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(ids, []string{"forever", "alive"}) {
+		t.Errorf("Unexpected routes %v", ids)
+	}
+
+	// Renewal of an expired lease brings it back to life.
+	add("alive", Lease(-time.Second))
+	add("alive", Lease(time.Hour))
+	if plan, err := caddyCfg.SweepExpiredRoutes(ctx); err != nil || len(plan) != 0 {
+		t.Errorf("Expected nothing to sweep, got %v, %v", plan, err)
+	}
+}
+
+func TestCaddyCfg_Heartbeat(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		caddyCfg.Heartbeat(ctx, "myserver", "app", ReverseProxyCaddyRouteConf(8080, []string{"app"}, "/*"), 300*time.Millisecond, func(err error) {
+			t.Errorf("%v", err)
+		}, Owner("app"), ReportChanges(func(changes []Change) {
+			t.Errorf("Unexpected changes %v", changes)
+		}))
+	}()
+	go func() {
+		defer wg.Done()
+		caddyCfg.Sweeper(ctx, 10*time.Millisecond, func(plan Plan, err error) {
+			if err != nil || len(plan) != 0 {
+				t.Errorf("Unexpected sweep %v, %v", plan, err)
+			}
+		})
+	}()
+
+	// Several renewals, and the route is never swept.
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	renewals := 0
+	for _, r := range admin.served() {
+		if strings.HasPrefix(r, "PATCH /id/app") {
+			renewals++
+		}
+	}
+	if renewals < 2 {
+		t.Errorf("Expected lease renewals, got %d", renewals)
+	}
+	owned, err := caddyCfg.ListOwnedRoutes(context.Background(), "app")
+	if err != nil || len(owned) != 1 {
+		t.Fatalf("Expected the route to stay, got %v, %v", owned, err)
+	}
+	if expires := routeMetaOf(owned[0].Config).Expires; expires == nil || expires.Before(time.Now()) {
+		t.Errorf("Unexpected lease in %s", owned[0].Config)
+	}
+
+	// Changes other than the lease are still reported.
+	var reported []Change
+	if err := caddyCfg.AddRoute("myserver", "app", ReverseProxyCaddyRouteConf(9000, []string{"app"}, "/*"), Owner("app"), Lease(300*time.Millisecond), ReportChanges(func(changes []Change) {
+		reported = changes
+	})); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(reported) != 1 || !strings.HasSuffix(reported[0].Path, "/dial") {
+		t.Errorf("Unexpected changes %v", reported)
+	}
+
+	// Once heartbeats stop, the route expires.
+	time.Sleep(400 * time.Millisecond)
+	plan, err := caddyCfg.SweepExpiredRoutes(context.Background())
+	if err != nil || plan.String() != `delete route 'app' from server 'myserver' at 0` {
+		t.Errorf("Unexpected sweep %v, %v", plan, err)
+	}
+}
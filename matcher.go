@@ -0,0 +1,225 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// Matcher is a single request matcher of a matcher set (https://caddyserver.com/docs/json/apps/http/servers/routes/match/),
+// made by MatchHost, MatchPath and the like.
+type Matcher struct {
+	// name is the key of the matcher in the matcher set, such as "host".
+	name string
+	// value is encoded as the configuration of the matcher, such as caddyhttp.MatchHost.
+	value any
+}
+
+// Name returns the key of the matcher in the matcher set, such as "host".
+func (m Matcher) Name() string {
+	return m.name
+}
+
+// MatchHost matches requests to any of hosts, which may have wildcards, such as "*.example.com".
+func MatchHost(hosts ...string) Matcher {
+	return Matcher{"host", caddyhttp.MatchHost(hosts)}
+}
+
+// MatchPath matches requests with any of paths, which may have wildcards, such as "/api/*".
+func MatchPath(paths ...string) Matcher {
+	return Matcher{"path", caddyhttp.MatchPath(paths)}
+}
+
+// MatchMethod matches requests with any of methods, such as http.MethodGet.
+func MatchMethod(methods ...string) Matcher {
+	return Matcher{"method", caddyhttp.MatchMethod(methods)}
+}
+
+// MatchHeader matches requests with header field having any of values, which may have wildcards at either end.
+// With no values, the field just has to be present.
+func MatchHeader(field string, values ...string) Matcher {
+	if values == nil {
+		values = []string{}
+	}
+	return Matcher{"header", caddyhttp.MatchHeader{http.CanonicalHeaderKey(field): values}}
+}
+
+// MatchHeaderRegexp matches requests with header field matching regular expression pattern.
+// Unless name is empty, the capture groups are available as "{http.regexp.<name>.<group>}" placeholders.
+func MatchHeaderRegexp(field string, name string, pattern string) Matcher {
+	return Matcher{"header_regexp", caddyhttp.MatchHeaderRE{
+		http.CanonicalHeaderKey(field): &caddyhttp.MatchRegexp{Name: name, Pattern: pattern},
+	}}
+}
+
+// MatchPathRegexp matches requests with path matching regular expression pattern.
+// Unless name is empty, the capture groups are available as "{http.regexp.<name>.<group>}" placeholders.
+func MatchPathRegexp(name string, pattern string) Matcher {
+	return Matcher{"path_regexp", caddyhttp.MatchPathRE{MatchRegexp: caddyhttp.MatchRegexp{Name: name, Pattern: pattern}}}
+}
+
+// MatchQuery matches requests with query parameter key having any of values, which may be "*" for any value.
+func MatchQuery(key string, values ...string) Matcher {
+	if values == nil {
+		values = []string{}
+	}
+	return Matcher{"query", caddyhttp.MatchQuery(url.Values{key: values})}
+}
+
+// MatchRemoteIP matches requests coming from any of ranges, which are IPs or CIDRs, such as "10.0.0.0/8".
+func MatchRemoteIP(ranges ...string) Matcher {
+	return Matcher{"remote_ip", caddyhttp.MatchRemoteIP{Ranges: ranges}}
+}
+
+// MatchClientIP matches requests of clients with IPs in any of ranges, the way MatchRemoteIP does,
+// but clients behind a proxy are told by the first IP of their "X-Forwarded-For" header.
+// Clients can easily spoof the header, so use it only behind a proxy that sets it.
+// MatchClientIP and MatchRemoteIP replace each other in a matcher set.
+//
+// It's the "remote_ip" matcher with "forwarded" set, which is what Caddy 2.6 has. Caddy 2.7 deprecates it
+// in favor of the "client_ip" matcher, which trusts the header of trusted proxies only.
+func MatchClientIP(ranges ...string) Matcher {
+	return Matcher{"remote_ip", caddyhttp.MatchRemoteIP{Ranges: ranges, Forwarded: true}}
+}
+
+// MatchProtocol matches requests of protocol, such as "https", "grpc", "http/2" or "http/2+".
+func MatchProtocol(protocol string) Matcher {
+	return Matcher{"protocol", caddyhttp.MatchProtocol(protocol)}
+}
+
+// MatchNot matches requests that don't match all of matchers. Several MatchNot in a matcher set
+// match requests that match none of their matchers.
+func MatchNot(matchers ...Matcher) Matcher {
+	return Matcher{"not", notMatcher{matcherSet(nil).add(matchers...)}}
+}
+
+// notMatcher is the "not" matcher, the sets of which are encoded along with the set it's in,
+// so that their errors are reported by RouteBuilder.Build.
+type notMatcher []matcherSet
+
+func (n notMatcher) MarshalJSON() ([]byte, error) {
+	sets := make([]caddy.ModuleMap, 0, len(n))
+	for _, set := range n {
+		m, err := set.moduleMap()
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, m)
+	}
+	return json.Marshal(sets)
+}
+
+// MatchExpression matches requests for which CEL expression expr (https://caddyserver.com/docs/caddyfile/matchers#expression)
+// is true, such as `{method} == "GET" && {path}.startsWith("/api")`.
+func MatchExpression(expr string) Matcher {
+	return Matcher{"expression", caddyhttp.MatchExpression{Expr: expr}}
+}
+
+// ModuleMatcher makes a Matcher out of the configuration of any Caddy matcher module, such as one of a plugin,
+// keyed by the name of the module.
+func ModuleMatcher(m caddy.Module) Matcher {
+	return Matcher{m.CaddyModule().ID.Name(), m}
+}
+
+// matcherSet is a matcher set being built.
+type matcherSet []Matcher
+
+// add adds matchers to the set. Matchers of lists, such as hosts, ranges and "not" sets, and of header fields
+// and query parameters are merged with the matchers of the same name already in the set; others are replaced.
+func (s matcherSet) add(matchers ...Matcher) matcherSet {
+next:
+	for _, m := range matchers {
+		for i, existing := range s {
+			if existing.name == m.name {
+				s[i] = merge(existing, m)
+				continue next
+			}
+		}
+		s = append(s, m)
+	}
+	return s
+}
+
+func merge(a, b Matcher) Matcher {
+	switch av := a.value.(type) {
+	case caddyhttp.MatchHost:
+		if bv, ok := b.value.(caddyhttp.MatchHost); ok {
+			return Matcher{a.name, append(av[:len(av):len(av)], bv...)}
+		}
+	case caddyhttp.MatchPath:
+		if bv, ok := b.value.(caddyhttp.MatchPath); ok {
+			return Matcher{a.name, append(av[:len(av):len(av)], bv...)}
+		}
+	case caddyhttp.MatchMethod:
+		if bv, ok := b.value.(caddyhttp.MatchMethod); ok {
+			return Matcher{a.name, append(av[:len(av):len(av)], bv...)}
+		}
+	case caddyhttp.MatchRemoteIP:
+		// Ranges of MatchRemoteIP and MatchClientIP are matched against different IPs, so they don't add up.
+		if bv, ok := b.value.(caddyhttp.MatchRemoteIP); ok && av.Forwarded == bv.Forwarded {
+			return Matcher{a.name, caddyhttp.MatchRemoteIP{Ranges: append(av.Ranges[:len(av.Ranges):len(av.Ranges)], bv.Ranges...), Forwarded: av.Forwarded}}
+		}
+	case notMatcher:
+		// Not A and not B is the same as not (A or B).
+		if bv, ok := b.value.(notMatcher); ok {
+			return Matcher{a.name, append(av[:len(av):len(av)], bv...)}
+		}
+	case caddyhttp.MatchQuery:
+		if bv, ok := b.value.(caddyhttp.MatchQuery); ok {
+			merged := caddyhttp.MatchQuery{}
+			for _, q := range []caddyhttp.MatchQuery{av, bv} {
+				for key, values := range q {
+					existing, ok := merged[key]
+					if !ok {
+						existing = []string{}
+					}
+					merged[key] = append(existing[:len(existing):len(existing)], values...)
+				}
+			}
+			return Matcher{a.name, merged}
+		}
+	case caddyhttp.MatchHeaderRE:
+		if bv, ok := b.value.(caddyhttp.MatchHeaderRE); ok {
+			merged := caddyhttp.MatchHeaderRE{}
+			for _, h := range []caddyhttp.MatchHeaderRE{av, bv} {
+				for field, re := range h {
+					merged[field] = re
+				}
+			}
+			return Matcher{a.name, merged}
+		}
+	case caddyhttp.MatchHeader:
+		if bv, ok := b.value.(caddyhttp.MatchHeader); ok {
+			merged := caddyhttp.MatchHeader{}
+			for _, h := range []caddyhttp.MatchHeader{av, bv} {
+				for field, values := range h {
+					// An empty list means the field has to be present, while nil would mean it has to be absent.
+					existing, ok := merged[field]
+					if !ok {
+						existing = []string{}
+					}
+					merged[field] = append(existing[:len(existing):len(existing)], values...)
+				}
+			}
+			return Matcher{a.name, merged}
+		}
+	}
+	return b
+}
+
+// moduleMap encodes the set the way caddyhttp.Route keeps it.
+func (s matcherSet) moduleMap() (caddy.ModuleMap, error) {
+	m := caddy.ModuleMap{}
+	for _, matcher := range s {
+		raw, err := json.Marshal(matcher.value)
+		if err != nil {
+			return nil, fmt.Errorf("encoding matcher %q: %w", matcher.name, err)
+		}
+		m[matcher.name] = raw
+	}
+	return m, nil
+}
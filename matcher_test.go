@@ -0,0 +1,67 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	for _, tt := range []struct {
+		matchers []Matcher
+		want     string
+	}{
+		{[]Matcher{MatchMethod(http.MethodGet), MatchMethod(http.MethodPost)}, `{"method":["GET","POST"]}`},
+		{[]Matcher{MatchHeader("x-api-key", "*")}, `{"header":{"X-Api-Key":["*"]}}`},
+		{[]Matcher{MatchHeaderRegexp("host", "sub", `^(\w+)\.example\.com$`), MatchHeaderRegexp("Cookie", "", "session=")}, `{"header_regexp":{"Host":{"name":"sub","pattern":"^(\\w+)\\.example\\.com$"},"Cookie":{"pattern":"session="}}}`},
+		{[]Matcher{MatchPathRegexp("static", `\.(css|js)$`)}, `{"path_regexp":{"name":"static","pattern":"\\.(css|js)$"}}`},
+		{[]Matcher{MatchQuery("debug"), MatchQuery("v", "1", "2")}, `{"query":{"debug":[],"v":["1","2"]}}`},
+		{[]Matcher{MatchRemoteIP("10.0.0.0/8"), MatchRemoteIP("192.168.0.1")}, `{"remote_ip":{"ranges":["10.0.0.0/8","192.168.0.1"]}}`},
+		{[]Matcher{MatchClientIP("203.0.113.0/24"), MatchClientIP("198.51.100.7")}, `{"remote_ip":{"ranges":["203.0.113.0/24","198.51.100.7"],"forwarded":true}}`},
+		{[]Matcher{MatchRemoteIP("10.0.0.0/8"), MatchClientIP("203.0.113.0/24")}, `{"remote_ip":{"ranges":["203.0.113.0/24"],"forwarded":true}}`},
+		{[]Matcher{MatchProtocol("grpc")}, `{"protocol":"grpc"}`},
+		{[]Matcher{MatchNot(MatchPath("/admin/*"), MatchMethod(http.MethodPost)), MatchNot(MatchRemoteIP("10.0.0.0/8"))}, `{"not":[{"path":["/admin/*"],"method":["POST"]},{"remote_ip":{"ranges":["10.0.0.0/8"]}}]}`},
+		{[]Matcher{MatchExpression(`{method} == "GET"`)}, `{"expression":"{method} == \"GET\""}`},
+		{[]Matcher{MatchProtocol("http"), MatchProtocol("https")}, `{"protocol":"https"}`},
+	} {
+		route, err := NewRoute().Match(tt.matchers...).Build()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(route.MatcherSetsRaw) != 1 {
+			t.Fatalf("Expected a single matcher set, got %v", route.MatcherSetsRaw)
+		}
+		b, err := json.Marshal(route.MatcherSetsRaw[0])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !EqualJSON(b, []byte(tt.want)) {
+			t.Errorf("Want %v, got %s", tt.want, b)
+		}
+	}
+
+	route, err := NewRoute().Or().Host("example.com").Or().Or().Host("example.net").Path("/legacy/*").Or().Build()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `{"match":[{"host":["example.com"]},{"host":["example.net"],"path":["/legacy/*"]}]}`; !EqualJSON(b, []byte(want)) {
+		t.Errorf("Want %v, got %s", want, b)
+	}
+	var decoded Route
+	if err := json.Unmarshal(b, &decoded); err != nil || len(decoded.Match) != 2 || decoded.Match[1].Path[0] != "/legacy/*" {
+		t.Errorf("Unexpected model %+v, %v", decoded, err)
+	}
+
+	otherRoute, err := NewRoute().Host("example.com").Or().Host("example.net").Path("/legacy/*").Match(MatchNot(MatchMethod(http.MethodDelete))).Build()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	other, _ := json.Marshal(otherRoute)
+	if RouteConfigsEqual(string(b), string(other)) {
+		t.Errorf("Expected %s and %s to differ", b, other)
+	}
+}
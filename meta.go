@@ -0,0 +1,110 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// metaVar is the name of the variable in which caddycfg keeps what it needs to remember about a route,
+// such as its priority, owner or lease.
+//
+// Caddy refuses routes with unknown fields, so the metadata is stored as a leading "vars" handler
+// of the route, which merely sets a variable nobody reads:
+//
+//	{"handler": "vars", "caddycfg": {"priority": 10}}
+const metaVar = "caddycfg"
+
+// routeMeta is the metadata caddycfg stores in routes.
+type routeMeta struct {
+	Priority *int   `json:"priority,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	// Expires is when the lease of the route lapses, always in UTC so that it compares equal after decoding.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+func (m routeMeta) empty() bool {
+	return reflect.DeepEqual(m, routeMeta{})
+}
+
+// metaHandler is the "vars" handler carrying routeMeta.
+type metaHandler struct {
+	Handler string     `json:"handler"`
+	Meta    *routeMeta `json:"caddycfg"`
+}
+
+// parseMetaHandler returns the metadata carried by handler, if it's a metaHandler.
+func parseMetaHandler(handler json.RawMessage) (routeMeta, bool) {
+	var h metaHandler
+	if json.Unmarshal(handler, &h) != nil || h.Handler != "vars" || h.Meta == nil {
+		return routeMeta{}, false
+	}
+	return *h.Meta, true
+}
+
+// routeMetaOf returns the metadata stored in route, which is empty for routes caddycfg didn't store any in.
+func routeMetaOf(route []byte) routeMeta {
+	var r struct {
+		Handle []json.RawMessage `json:"handle"`
+	}
+	if json.Unmarshal(route, &r) != nil || len(r.Handle) == 0 {
+		return routeMeta{}
+	}
+	meta, _ := parseMetaHandler(r.Handle[0])
+	return meta
+}
+
+// withRouteMeta returns route with its metadata replaced with meta. Empty meta removes the metadata.
+func withRouteMeta(route string, meta routeMeta) (string, error) {
+	var r map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(route), &r); err != nil {
+		return "", err
+	}
+	var handle []json.RawMessage
+	if h, ok := r["handle"]; ok {
+		if err := json.Unmarshal(h, &handle); err != nil {
+			return "", err
+		}
+	}
+	if len(handle) > 0 {
+		if _, ok := parseMetaHandler(handle[0]); ok {
+			handle = handle[1:]
+		}
+	}
+	if !meta.empty() {
+		h, err := json.Marshal(metaHandler{Handler: "vars", Meta: &meta})
+		if err != nil {
+			return "", err
+		}
+		handle = append([]json.RawMessage{h}, handle...)
+	}
+	if len(handle) == 0 {
+		delete(r, "handle")
+	} else {
+		h, err := json.Marshal(handle)
+		if err != nil {
+			return "", err
+		}
+		r["handle"] = h
+	}
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+// withoutExpires returns route with the lease left out of its metadata, or route itself if it has none.
+func withoutExpires(route string) string {
+	meta := routeMetaOf([]byte(route))
+	if meta.Expires == nil {
+		return route
+	}
+	meta.Expires = nil
+	if stripped, err := withRouteMeta(route, meta); err == nil {
+		return stripped
+	}
+	return route
+}
+
+// sameRoute tells if route configurations cfg0 and cfg1 are equal along with their metadata.
+func sameRoute(cfg0, cfg1 string) bool {
+	return RouteConfigsEqual(cfg0, cfg1) && reflect.DeepEqual(routeMetaOf([]byte(cfg0)), routeMetaOf([]byte(cfg1)))
+}
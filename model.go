@@ -0,0 +1,429 @@
+package caddycfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Config is Caddy's JSON configuration (https://caddyserver.com/docs/json/), typed for the parts caddycfg deals with.
+//
+// Every type of the model keeps the fields it doesn't know in Extra, and the modules it doesn't know as
+// json.RawMessage, so that a configuration decoded with json.Unmarshal and encoded back with json.Marshal
+// stays the same, except for the order of the fields. Fields that are absent stay absent, while empty ones stay empty,
+// and so do explicit zero values, such as false or null.
+//
+// The model is good for reading configurations and changing them in Go:
+//
+//	var config caddycfg.Config
+//	err := caddyCfg.Get(ctx, &config)
+//	...
+//	config.Apps.HTTP.Servers["myserver"].Routes = append(config.Apps.HTTP.Servers["myserver"].Routes, &caddycfg.Route{...})
+//	err = caddyCfg.Set(ctx, &config)
+type Config struct {
+	ID      string          `json:"@id,omitempty"`
+	Admin   *Admin          `json:"admin,omitempty"`
+	Logging json.RawMessage `json:"logging,omitempty"`
+	Storage json.RawMessage `json:"storage,omitempty"`
+	Apps    *Apps           `json:"apps,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Admin is the "admin" section of Config.
+type Admin struct {
+	ID            string          `json:"@id,omitempty"`
+	Disabled      bool            `json:"disabled,omitempty"`
+	Listen        string          `json:"listen,omitempty"`
+	EnforceOrigin bool            `json:"enforce_origin,omitempty"`
+	Origins       []string        `json:"origins,omitempty"`
+	Config        json.RawMessage `json:"config,omitempty"`
+	Identity      json.RawMessage `json:"identity,omitempty"`
+	Remote        json.RawMessage `json:"remote,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Apps is the "apps" section of Config.
+type Apps struct {
+	HTTP *HTTPApp        `json:"http,omitempty"`
+	TLS  json.RawMessage `json:"tls,omitempty"`
+	// Extra keeps the apps not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// HTTPApp is the "http" app (https://caddyserver.com/docs/json/apps/http/).
+type HTTPApp struct {
+	ID        string             `json:"@id,omitempty"`
+	HTTPPort  int                `json:"http_port,omitempty"`
+	HTTPSPort int                `json:"https_port,omitempty"`
+	Servers   map[string]*Server `json:"servers,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Server is an entry of "servers" of HTTPApp (https://caddyserver.com/docs/json/apps/http/servers/).
+type Server struct {
+	ID                    string          `json:"@id,omitempty"`
+	Listen                []string        `json:"listen,omitempty"`
+	Routes                []*Route        `json:"routes,omitempty"`
+	Errors                *ServerErrors   `json:"errors,omitempty"`
+	TLSConnectionPolicies json.RawMessage `json:"tls_connection_policies,omitempty"`
+	AutomaticHTTPS        *AutomaticHTTPS `json:"automatic_https,omitempty"`
+	Logs                  json.RawMessage `json:"logs,omitempty"`
+	Protocols             []string        `json:"protocols,omitempty"`
+	// Extra keeps the fields not known to the model, such as timeouts.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ServerErrors is the "errors" section of Server.
+type ServerErrors struct {
+	Routes []*Route `json:"routes,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// AutomaticHTTPS is the "automatic_https" section of Server.
+type AutomaticHTTPS struct {
+	Disable           bool     `json:"disable,omitempty"`
+	DisableRedirects  bool     `json:"disable_redirects,omitempty"`
+	Skip              []string `json:"skip,omitempty"`
+	SkipCerts         []string `json:"skip_certificates,omitempty"`
+	IgnoreLoadedCerts bool     `json:"ignore_loaded_certificates,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Route is an element of "routes" (https://caddyserver.com/docs/json/apps/http/servers/routes/).
+//
+// Unlike caddyhttp.Route, it has a field for "@id".
+type Route struct {
+	ID       string       `json:"@id,omitempty"`
+	Group    string       `json:"group,omitempty"`
+	Match    []MatcherSet `json:"match,omitempty"`
+	Handle   Handlers     `json:"handle,omitempty"`
+	Terminal bool         `json:"terminal,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// MatcherSet is an element of "match" of a Route. All the matchers of a set have to match for the set to match.
+type MatcherSet struct {
+	Host       []string            `json:"host,omitempty"`
+	Path       []string            `json:"path,omitempty"`
+	Method     []string            `json:"method,omitempty"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Query      map[string][]string `json:"query,omitempty"`
+	Protocol   string              `json:"protocol,omitempty"`
+	RemoteIP   *RemoteIPMatcher    `json:"remote_ip,omitempty"`
+	Not        []MatcherSet        `json:"not,omitempty"`
+	Expression json.RawMessage     `json:"expression,omitempty"`
+	// Extra keeps the matchers not known to the model, such as "path_regexp".
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// RemoteIPMatcher is the "remote_ip" matcher of MatcherSet.
+type RemoteIPMatcher struct {
+	Ranges []string `json:"ranges,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Handler is an element of "handle" of a Route, told apart by its "handler" field.
+// Handlers not known to the model are decoded as *RawHandler.
+type Handler interface {
+	// HandlerName is the value of the "handler" field.
+	HandlerName() string
+}
+
+// Handlers is a list of handlers decoded into their types by the "handler" field.
+type Handlers []Handler
+
+// handlerTypes maps "handler" names to the types of the model.
+var handlerTypes = map[string]reflect.Type{
+	"reverse_proxy":   reflect.TypeOf(ReverseProxyHandler{}),
+	"static_response": reflect.TypeOf(StaticResponseHandler{}),
+	"subroute":        reflect.TypeOf(SubrouteHandler{}),
+	"file_server":     reflect.TypeOf(FileServerHandler{}),
+	"vars":            reflect.TypeOf(VarsHandler{}),
+}
+
+func (h *Handlers) UnmarshalJSON(b []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return err
+	}
+	if raws == nil {
+		*h = nil
+		return nil
+	}
+	handlers := make(Handlers, len(raws))
+	for i, raw := range raws {
+		var name struct {
+			Handler string `json:"handler"`
+		}
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return err
+		}
+		t, ok := handlerTypes[name.Handler]
+		if !ok {
+			handlers[i] = &RawHandler{Name: name.Handler, Raw: raw}
+			continue
+		}
+		v := reflect.New(t)
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return fmt.Errorf("decoding %v handler: %w", name.Handler, err)
+		}
+		handlers[i] = v.Interface().(Handler)
+	}
+	*h = handlers
+	return nil
+}
+
+// RawHandler is a handler not known to the model, kept as is.
+type RawHandler struct {
+	Name string
+	Raw  json.RawMessage
+}
+
+func (h *RawHandler) HandlerName() string          { return h.Name }
+func (h *RawHandler) MarshalJSON() ([]byte, error) { return h.Raw, nil }
+
+// ReverseProxyHandler is the "reverse_proxy" handler (https://caddyserver.com/docs/json/apps/http/servers/routes/handle/reverse_proxy/).
+type ReverseProxyHandler struct {
+	Transport     json.RawMessage `json:"transport,omitempty"`
+	Upstreams     []Upstream      `json:"upstreams,omitempty"`
+	LoadBalancing json.RawMessage `json:"load_balancing,omitempty"`
+	HealthChecks  json.RawMessage `json:"health_checks,omitempty"`
+	Headers       json.RawMessage `json:"headers,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// Upstream is an element of "upstreams" of ReverseProxyHandler.
+type Upstream struct {
+	Dial        string `json:"dial,omitempty"`
+	MaxRequests int    `json:"max_requests,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// StaticResponseHandler is the "static_response" handler (https://caddyserver.com/docs/json/apps/http/servers/routes/handle/static_response/).
+type StaticResponseHandler struct {
+	StatusCode json.RawMessage     `json:"status_code,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	Close      bool                `json:"close,omitempty"`
+	Abort      bool                `json:"abort,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// SubrouteHandler is the "subroute" handler (https://caddyserver.com/docs/json/apps/http/servers/routes/handle/subroute/).
+type SubrouteHandler struct {
+	Routes []*Route      `json:"routes,omitempty"`
+	Errors *ServerErrors `json:"errors,omitempty"`
+	// Extra keeps the fields not known to the model.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// FileServerHandler is the "file_server" handler (https://caddyserver.com/docs/json/apps/http/servers/routes/handle/file_server/).
+type FileServerHandler struct {
+	Root       string          `json:"root,omitempty"`
+	Hide       []string        `json:"hide,omitempty"`
+	IndexNames []string        `json:"index_names,omitempty"`
+	Browse     json.RawMessage `json:"browse,omitempty"`
+	PassThru   bool            `json:"pass_thru,omitempty"`
+	// Extra keeps the fields not known to the model, such as "precompressed".
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// VarsHandler is the "vars" handler (https://caddyserver.com/docs/json/apps/http/servers/routes/handle/vars/),
+// which sets the variables named by its keys.
+type VarsHandler map[string]json.RawMessage
+
+func (ReverseProxyHandler) HandlerName() string   { return "reverse_proxy" }
+func (StaticResponseHandler) HandlerName() string { return "static_response" }
+func (SubrouteHandler) HandlerName() string       { return "subroute" }
+func (FileServerHandler) HandlerName() string     { return "file_server" }
+func (VarsHandler) HandlerName() string           { return "vars" }
+
+func (h ReverseProxyHandler) MarshalJSON() ([]byte, error)  { return marshalObject(&h, handlerField(h)) }
+func (h *ReverseProxyHandler) UnmarshalJSON(b []byte) error { return unmarshalObject(b, h) }
+func (h StaticResponseHandler) MarshalJSON() ([]byte, error) {
+	return marshalObject(&h, handlerField(h))
+}
+func (h *StaticResponseHandler) UnmarshalJSON(b []byte) error { return unmarshalObject(b, h) }
+func (h SubrouteHandler) MarshalJSON() ([]byte, error)        { return marshalObject(&h, handlerField(h)) }
+func (h *SubrouteHandler) UnmarshalJSON(b []byte) error       { return unmarshalObject(b, h) }
+func (h FileServerHandler) MarshalJSON() ([]byte, error)      { return marshalObject(&h, handlerField(h)) }
+func (h *FileServerHandler) UnmarshalJSON(b []byte) error     { return unmarshalObject(b, h) }
+
+func (h VarsHandler) MarshalJSON() ([]byte, error) {
+	vars := make(map[string]json.RawMessage, len(h)+1)
+	for k, v := range h {
+		vars[k] = v
+	}
+	vars["handler"] = json.RawMessage(`"vars"`)
+	return json.Marshal(vars)
+}
+
+func (h *VarsHandler) UnmarshalJSON(b []byte) error {
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(b, &vars); err != nil {
+		return err
+	}
+	delete(vars, "handler")
+	*h = vars
+	return nil
+}
+
+// The types of the model go through marshalObject and unmarshalObject to keep Extra.
+
+func (c Config) MarshalJSON() ([]byte, error)           { return marshalObject(&c, nil) }
+func (c *Config) UnmarshalJSON(b []byte) error          { return unmarshalObject(b, c) }
+func (a Admin) MarshalJSON() ([]byte, error)            { return marshalObject(&a, nil) }
+func (a *Admin) UnmarshalJSON(b []byte) error           { return unmarshalObject(b, a) }
+func (a Apps) MarshalJSON() ([]byte, error)             { return marshalObject(&a, nil) }
+func (a *Apps) UnmarshalJSON(b []byte) error            { return unmarshalObject(b, a) }
+func (h HTTPApp) MarshalJSON() ([]byte, error)          { return marshalObject(&h, nil) }
+func (h *HTTPApp) UnmarshalJSON(b []byte) error         { return unmarshalObject(b, h) }
+func (s Server) MarshalJSON() ([]byte, error)           { return marshalObject(&s, nil) }
+func (s *Server) UnmarshalJSON(b []byte) error          { return unmarshalObject(b, s) }
+func (e ServerErrors) MarshalJSON() ([]byte, error)     { return marshalObject(&e, nil) }
+func (e *ServerErrors) UnmarshalJSON(b []byte) error    { return unmarshalObject(b, e) }
+func (a AutomaticHTTPS) MarshalJSON() ([]byte, error)   { return marshalObject(&a, nil) }
+func (a *AutomaticHTTPS) UnmarshalJSON(b []byte) error  { return unmarshalObject(b, a) }
+func (r Route) MarshalJSON() ([]byte, error)            { return marshalObject(&r, nil) }
+func (r *Route) UnmarshalJSON(b []byte) error           { return unmarshalObject(b, r) }
+func (m MatcherSet) MarshalJSON() ([]byte, error)       { return marshalObject(&m, nil) }
+func (m *MatcherSet) UnmarshalJSON(b []byte) error      { return unmarshalObject(b, m) }
+func (u Upstream) MarshalJSON() ([]byte, error)         { return marshalObject(&u, nil) }
+func (u *Upstream) UnmarshalJSON(b []byte) error        { return unmarshalObject(b, u) }
+func (m RemoteIPMatcher) MarshalJSON() ([]byte, error)  { return marshalObject(&m, nil) }
+func (m *RemoteIPMatcher) UnmarshalJSON(b []byte) error { return unmarshalObject(b, m) }
+
+// handlerField returns the "handler" field of h to lead its JSON.
+func handlerField(h Handler) []byte {
+	b, _ := json.Marshal(h.HandlerName())
+	return append([]byte(`"handler":`), b...)
+}
+
+// unmarshalObject decodes JSON object b into the fields of v, a pointer to a struct of the model,
+// by their json tags, and puts the fields left over into its Extra.
+//
+// Fields that marshalObject would leave out, such as an explicit false or null, stay in Extra as well,
+// so that they are written back as they were.
+func unmarshalObject(b []byte, v any) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if _, ok := v.(Handler); ok {
+		// marshalObject writes it from HandlerName.
+		delete(fields, "handler")
+	}
+	s := reflect.ValueOf(v).Elem()
+	s.Set(reflect.Zero(s.Type()))
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, omitEmpty := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, s.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("decoding %q: %w", name, err)
+		}
+		if !omitted(s.Field(i), omitEmpty) {
+			delete(fields, name)
+		}
+	}
+	if extra := s.FieldByName("Extra"); extra.IsValid() && len(fields) > 0 {
+		extra.Set(reflect.ValueOf(fields))
+	}
+	return nil
+}
+
+// marshalObject encodes the fields of v, a pointer to a struct of the model, along with its Extra,
+// into a JSON object, which starts with lead, unless it's empty.
+//
+// Unlike json.Marshal with "omitempty", which leaves out empty slices and maps too, only nil ones are left out,
+// so that an empty list in the original JSON stays there.
+func marshalObject(v any, lead []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	b.Write(lead)
+	comma := len(lead) > 0
+	write := func(name string, value []byte) {
+		if comma {
+			b.WriteByte(',')
+		}
+		comma = true
+		n, _ := json.Marshal(name)
+		b.Write(n)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+
+	// written keeps Extra from repeating the fields written already.
+	written := map[string]bool{}
+	if _, ok := v.(Handler); ok {
+		written["handler"] = true
+	}
+	s := reflect.ValueOf(v).Elem()
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, omitEmpty := jsonName(t.Field(i))
+		if name == "" || omitted(s.Field(i), omitEmpty) {
+			continue
+		}
+		value, err := json.Marshal(s.Field(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("encoding %q: %w", name, err)
+		}
+		write(name, value)
+		written[name] = true
+	}
+
+	if extra, ok := s.FieldByName("Extra").Interface().(map[string]json.RawMessage); ok {
+		names := make([]string, 0, len(extra))
+		for name := range extra {
+			if !written[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			write(name, extra[name])
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// omitted tells whether marshalObject leaves field f out: nil slices, maps and pointers,
+// and zero values of fields tagged with "omitempty".
+func omitted(f reflect.Value, omitEmpty bool) bool {
+	switch f.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return f.IsNil()
+	}
+	return omitEmpty && f.IsZero()
+}
+
+// jsonName returns the JSON name of struct field f, or "" if it's not encoded by its tag.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag, ok := f.Tag.Lookup("json")
+	if !ok || tag == "-" || !f.IsExported() {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, strings.Contains(","+opts+",", ",omitempty,")
+}
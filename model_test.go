@@ -0,0 +1,200 @@
+package caddycfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const modelTestConfig = `{
+	"@id": "root",
+	"admin": {"listen": "unix//run/caddy-admin.sock", "origins": ["localhost"], "config": {"persist": false}},
+	"logging": {"logs": {"default": {"level": "DEBUG"}}},
+	"apps": {
+		"tls": {"automation": {"policies": [{"issuers": [{"module": "internal"}]}]}},
+		"pki": {"certificate_authorities": {"local": {"install_trust": false}}},
+		"http": {
+			"http_port": 8080,
+			"grace_period": "10s",
+			"servers": {
+				"myserver": {
+					"listen": [":443"],
+					"read_timeout": 5000000000,
+					"automatic_https": {"skip": []},
+					"routes": [
+						{
+							"@id": "example.com",
+							"match": [
+								{"host": ["example.com"], "path": ["/*"], "path_regexp": {"name": "re", "pattern": "^/a"}},
+								{"remote_ip": {"ranges": ["10.0.0.0/8"], "forwarded": true}, "not": [{"method": ["POST"]}]},
+								{"expression": "{http.request.uri.path} == '/'", "header": {"X-A": ["b"]}}
+							],
+							"handle": [
+								{"handler": "vars", "caddycfg": {"priority": 1}},
+								{
+									"handler": "reverse_proxy",
+									"transport": {"protocol": "http", "read_buffer_size": 4096},
+									"upstreams": [{"dial": "localhost:8080", "max_requests": 10}, {"dial": "localhost:8081", "unknown": 1, "max_requests": 0}],
+									"load_balancing": {"selection_policy": {"policy": "round_robin"}},
+									"flush_interval": -1
+								},
+								{"handler": "subroute", "routes": [{"group": "", "handle": [{"handler": "static_response", "body": "hi", "status_code": 200, "close": false}], "terminal": false}]},
+								{"handler": "file_server", "root": "/srv", "hide": [], "index_names": null, "pass_thru": false, "precompressed": {"gzip": {}}},
+								{"handler": "encode", "encodings": {"gzip": {}}}
+							],
+							"terminal": true
+						}
+					]
+				}
+			}
+		}
+	}
+}`
+
+func TestConfig_RoundTrip(t *testing.T) {
+	var config Config
+	if err := json.Unmarshal([]byte(modelTestConfig), &config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want, got := canonicalJSON(t, []byte(modelTestConfig)), canonicalJSON(t, b); want != got {
+		t.Errorf("Round trip error, want:\n%v\ngot:\n%v", want, got)
+	}
+
+	// Typed access.
+	if config.ID != "root" || config.Admin.Listen != "unix//run/caddy-admin.sock" || config.Apps.HTTP.HTTPPort != 8080 {
+		t.Errorf("Unexpected %+v", config)
+	}
+	server := config.Apps.HTTP.Servers["myserver"]
+	if server.AutomaticHTTPS.Skip == nil || len(server.AutomaticHTTPS.Skip) != 0 {
+		t.Errorf("Unexpected skip %#v", server.AutomaticHTTPS.Skip)
+	}
+	if _, ok := server.Extra["read_timeout"]; !ok {
+		t.Errorf("Unexpected extra %v", server.Extra)
+	}
+	route := server.Routes[0]
+	if route.ID != "example.com" || !route.Terminal || route.Match[0].Host[0] != "example.com" || route.Match[1].Not[0].Method[0] != "POST" {
+		t.Errorf("Unexpected route %+v", route)
+	}
+	if route.Match[1].RemoteIP.Ranges[0] != "10.0.0.0/8" {
+		t.Errorf("Unexpected remote_ip %+v", route.Match[1].RemoteIP)
+	}
+	names := []string{}
+	for _, h := range route.Handle {
+		names = append(names, h.HandlerName())
+	}
+	if want := []string{"vars", "reverse_proxy", "subroute", "file_server", "encode"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Want handlers %v, got %v", want, names)
+	}
+	rp := route.Handle[1].(*ReverseProxyHandler)
+	if rp.Upstreams[1].Dial != "localhost:8081" || rp.Upstreams[0].MaxRequests != 10 {
+		t.Errorf("Unexpected upstreams %+v", rp.Upstreams)
+	}
+	if sr := route.Handle[2].(*SubrouteHandler).Routes[0].Handle[0].(*StaticResponseHandler); sr.Body != "hi" {
+		t.Errorf("Unexpected static response %+v", sr)
+	}
+	if _, ok := route.Handle[4].(*RawHandler); !ok {
+		t.Errorf("Expected *RawHandler, got %T", route.Handle[4])
+	}
+
+	// Decoded handlers encode on their own the way they were.
+	b, err = json.Marshal(route.Handle[3])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `{"handler":"file_server","root":"/srv","hide":[],"index_names":null,"pass_thru":false,"precompressed":{"gzip":{}}}`; canonicalJSON(t, b) != canonicalJSON(t, []byte(want)) {
+		t.Errorf("Want %v, got %s", want, b)
+	}
+	// Set fields take the place of the explicit zero values they had.
+	route.Handle[3].(*FileServerHandler).PassThru = true
+	b, _ = json.Marshal(route.Handle[3])
+	if want := `{"handler":"file_server","root":"/srv","hide":[],"index_names":null,"pass_thru":true,"precompressed":{"gzip":{}}}`; canonicalJSON(t, b) != canonicalJSON(t, []byte(want)) {
+		t.Errorf("Want %v, got %s", want, b)
+	}
+}
+
+func TestConfig_Modify(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	var config Config
+	if err := caddyCfg.Get(ctx, &config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	server := config.Apps.HTTP.Servers["myserver"]
+	server.Routes = append(server.Routes, &Route{
+		ID:    "example.com",
+		Match: []MatcherSet{{Host: []string{"example.com"}}},
+		Handle: Handlers{
+			&ReverseProxyHandler{Upstreams: []Upstream{{Dial: "localhost:8080"}}},
+			StaticResponseHandler{Body: "unreachable"},
+		},
+		Terminal: true,
+	})
+	if err := caddyCfg.Set(ctx, &config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{"admin":{"listen":"localhost:2019"},"apps":{"http":{"servers":{"myserver":{"automatic_https":{"skip":[]},"listen":[":443"],"routes":[{"@id":"example.com","handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]},{"body":"unreachable","handler":"static_response"}],"match":[{"host":["example.com"]}],"terminal":true}]}}}}}`
+	if c := admin.config(); c != want {
+		t.Errorf("Config error, want:\n%v\ngot:\n%v", want, c)
+	}
+	route, err := GetByID[Route](ctx, caddyCfg, "example.com")
+	if err != nil || route.Handle[0].(*ReverseProxyHandler).Upstreams[0].Dial != "localhost:8080" {
+		t.Errorf("Unexpected route %+v, %v", route, err)
+	}
+}
+
+// canonicalJSON returns b with its object keys sorted and whitespace removed,
+// failing t if any object of b has duplicate keys.
+func canonicalJSON(t *testing.T, b []byte) string {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var check func() error
+	check = func() error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			keys := map[string]bool{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if keys[key.(string)] {
+					return fmt.Errorf("duplicate key %q", key)
+				}
+				keys[key.(string)] = true
+				if err := check(); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for dec.More() {
+				if err := check(); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	if err := check(); err != nil {
+		t.Fatalf("%v in %s", err, b)
+	}
+	v, err := decodeJSON(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return string(encodeJSON(v))
+}
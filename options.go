@@ -0,0 +1,209 @@
+package caddycfg
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Option configures CaddyCfg created with NewCaddyCfg.
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    time.Duration
+}
+
+// WithHTTPClient makes all the admin requests go through httpClient instead of http.DefaultClient.
+//
+// httpClient itself is never modified: WithTransport and WithTimeout are applied to a copy of it.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// WithTransport replaces the transport of the HTTP client used for the admin requests.
+// This is a good place to plug in logging, proxies or a test transport.
+//
+// Admin endpoints listening on a unix socket need an *http.Transport, a copy of which is made to dial the socket.
+// Other transports are in charge of their own dialing, which can't be pointed to the socket, so New fails
+// for them with an *AddressError matching errors.Is(err, ErrSocketTransport). The same goes for the transport
+// of the client of WithHTTPClient.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithTimeout limits every single admin request to timeout, including reading of the response body.
+// Zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// newHTTPClient builds the client used by CaddyCfg out of the applied options.
+// Unless socket is empty, the client dials every request through that unix socket,
+// which fails for transports other than *http.Transport.
+func (o options) newHTTPClient(socket string) (*http.Client, error) {
+	c := http.DefaultClient
+	if o.httpClient != nil {
+		c = o.httpClient
+	}
+	if o.transport == nil && o.timeout == 0 && socket == "" {
+		return c, nil
+	}
+	cc := *c
+	if o.transport != nil {
+		cc.Transport = o.transport
+	}
+	if o.timeout != 0 {
+		cc.Timeout = o.timeout
+	}
+	if socket != "" {
+		t, err := socketTransport(cc.Transport, socket)
+		if err != nil {
+			return nil, err
+		}
+		cc.Transport = t
+	}
+	return &cc, nil
+}
+
+// socketTransport returns a copy of transport (http.DefaultTransport if nil) dialing socket for every connection.
+//
+// Custom http.RoundTripper implementations other than *http.Transport are in charge of their own dialing,
+// which would go to "127.0.0.1" over TCP rather than to socket, so they fail.
+func socketTransport(transport http.RoundTripper, socket string) (http.RoundTripper, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an *http.Transport", transport)
+	}
+	t = t.Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	return t, nil
+}
+
+// RouteOption tweaks CaddyCfg.AddRoute.
+type RouteOption func(*routeOptions)
+
+type routeOptions struct {
+	verify   bool
+	place    placement
+	priority *int
+	owner    string
+	lease    time.Duration
+	report   func(changes []Change)
+}
+
+// meta returns the metadata the route is to be stored with.
+func (o routeOptions) meta() routeMeta {
+	meta := routeMeta{Priority: o.priority, Owner: o.owner}
+	if o.lease != 0 {
+		expires := time.Now().Add(o.lease).UTC()
+		meta.Expires = &expires
+	}
+	return meta
+}
+
+// VerifyRoute makes AddRoute read the route back by its "@id" after writing it,
+// and fail with ErrRouteNotApplied if Caddy's configuration doesn't have it.
+func VerifyRoute() RouteOption {
+	return func(o *routeOptions) {
+		o.verify = true
+	}
+}
+
+// Before makes AddRoute put the route right before the route with "@id" id among the routes of the server,
+// moving it there if it's elsewhere. If there's no such route, AddRoute fails with ErrRouteNotFound.
+//
+// The last of Before, After, AtIndex and Priority decides the position of the route.
+func Before(id string) RouteOption {
+	return func(o *routeOptions) {
+		o.place = placeBefore(id)
+	}
+}
+
+// After makes AddRoute put the route right after the route with "@id" id among the routes of the server,
+// moving it there if it's elsewhere. If there's no such route, AddRoute fails with ErrRouteNotFound.
+//
+// The last of Before, After, AtIndex and Priority decides the position of the route.
+func After(id string) RouteOption {
+	return func(o *routeOptions) {
+		o.place = placeAfter(id)
+	}
+}
+
+// AtIndex makes AddRoute put the route at index among the routes of the server, moving it there if it's elsewhere.
+// An index past the end of the routes puts the route to the end.
+//
+// The last of Before, After, AtIndex and Priority decides the position of the route.
+func AtIndex(index int) RouteOption {
+	return func(o *routeOptions) {
+		o.place = placeAt(index)
+	}
+}
+
+// Priority makes AddRoute keep the routes added with Priority sorted from the highest priority to the lowest,
+// which is the order Caddy tries them in. For instance, giving routes their path length as priority
+// puts the most specific paths first, so that a "/*" route doesn't shadow an "/api/*" one
+// no matter which of them was added first.
+//
+// The route stays where it is as long as it's in order, and otherwise goes right after the last route of the same
+// or higher priority. Routes added without Priority keep their positions and don't affect the order.
+//
+// The priority is stored in the route itself as a leading "vars" handler.
+//
+// The last of Before, After, AtIndex and Priority decides the position of the route.
+func Priority(priority int) RouteOption {
+	return func(o *routeOptions) {
+		o.priority = &priority
+		o.place = placeByPriority(priority)
+	}
+}
+
+// Owner marks the route as owned by owner, so that it's listed by ListOwnedRoutes and can be
+// garbage collected with PruneOwnedRoutes. A good candidate for owner is the name of the app adding the route.
+//
+// The owner is stored in the route itself as a leading "vars" handler, so it has to be passed every time
+// the route is added, otherwise the route is disowned.
+func Owner(owner string) RouteOption {
+	return func(o *routeOptions) {
+		o.owner = owner
+	}
+}
+
+// Lease attaches a lease of ttl to the route, after which the route is considered expired
+// and gets deleted by SweepExpiredRoutes. Every AddRoute with Lease renews the lease, see Heartbeat.
+//
+// The time the lease lapses at is stored in the route itself as a leading "vars" handler, so the clocks
+// of the apps adding routes and sweeping them have to be roughly in sync. For the same reason, every renewal
+// is a change of the route, which makes Caddy reload its whole configuration, so leases shouldn't be renewed
+// more often than needed. ReportChanges doesn't report renewals.
+func Lease(ttl time.Duration) RouteOption {
+	return func(o *routeOptions) {
+		o.lease = ttl
+	}
+}
+
+// ReportChanges makes AddRoute call report with the differences between the route configuration
+// it has replaced and the new one, found with Diff. It's not called when the route is added anew or only moved.
+//
+// This is a good place to log why a route got updated.
+func ReportChanges(report func(changes []Change)) RouteOption {
+	return func(o *routeOptions) {
+		o.report = report
+	}
+}
@@ -0,0 +1,96 @@
+package caddycfg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTransport counts requests per method before passing them to http.DefaultTransport.
+type countingTransport struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = map[string]int{}
+	}
+	c.counts[req.Method]++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	transport := &countingTransport{}
+	caddyCfg := NewCaddyCfg(srv.URL, WithTransport(transport))
+
+	if err := caddyCfg.UploadTo(srv.URL, "{}"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Upload("{}"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := caddyCfg.Config(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := caddyCfg.ConfigById("example.com"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.DeleteById("example.com"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.AddRoute("myserver", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := map[string]int{http.MethodPost: 2, http.MethodPatch: 1, http.MethodGet: 3, http.MethodDelete: 1}
+	for method, n := range want {
+		if transport.counts[method] != n {
+			t.Errorf("%v: want %d requests, got %d", method, n, transport.counts[method])
+		}
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	client := &http.Client{}
+	caddyCfg := NewCaddyCfg(CaddyConfigURL, WithHTTPClient(client))
+	if caddyCfg.httpClient != client {
+		t.Errorf("Expected the passed client to be used as is")
+	}
+
+	transport := &countingTransport{}
+	caddyCfg = NewCaddyCfg(CaddyConfigURL, WithHTTPClient(client), WithTransport(transport), WithTimeout(time.Second))
+	if client.Transport != nil || client.Timeout != 0 {
+		t.Errorf("Expected the passed client to stay intact, got %+v", client)
+	}
+	if caddyCfg.httpClient.Transport != transport || caddyCfg.httpClient.Timeout != time.Second {
+		t.Errorf("Expected transport and timeout to be applied, got %+v", caddyCfg.httpClient)
+	}
+	if http.DefaultClient.Timeout != 0 {
+		t.Errorf("Expected http.DefaultClient to stay intact")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	srv := newHangingAdmin()
+	defer srv.Close()
+	caddyCfg := NewCaddyCfg(srv.URL, WithTimeout(50*time.Millisecond))
+	done := make(chan error)
+	go func() {
+		_, err := caddyCfg.Config()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected timeout error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout was not applied")
+	}
+}
@@ -0,0 +1,145 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// OwnedRoute is a route marked with Owner.
+type OwnedRoute struct {
+	// ServerKey is the "apps"."http"."servers" entry the route belongs to.
+	ServerKey string
+	// RouteID is the "@id" of the route.
+	RouteID string
+	// Index is the position of the route in the server's routes.
+	Index int
+	// Config is the route configuration as Caddy has it.
+	Config json.RawMessage
+}
+
+// ListOwnedRoutes returns the routes of all the servers marked as owned by owner with Owner,
+// ordered by server key and position. An empty owner owns nothing.
+func (caddyCfg *CaddyCfg) ListOwnedRoutes(ctx context.Context, owner string) ([]OwnedRoute, error) {
+	var servers map[string]struct {
+		Routes []json.RawMessage `json:"routes"`
+	}
+	if err := caddyCfg.Get(ctx, &servers, "apps", "http", "servers"); err != nil {
+		return nil, err
+	}
+	serverKeys := make([]string, 0, len(servers))
+	for serverKey := range servers {
+		serverKeys = append(serverKeys, serverKey)
+	}
+	sort.Strings(serverKeys)
+
+	var owned []OwnedRoute
+	for _, serverKey := range serverKeys {
+		for i, r := range servers[serverKey].Routes {
+			if id, ok := ownedBy(r, owner); ok {
+				owned = append(owned, OwnedRoute{ServerKey: serverKey, RouteID: id, Index: i, Config: r})
+			}
+		}
+	}
+	return owned, nil
+}
+
+// PruneOwnedRoutes deletes the routes of all the servers marked as owned by owner with Owner,
+// except for the ones with "@id" in keep, and returns the deletions made. Routes of other owners are never touched.
+// An empty owner owns nothing.
+//
+// This lets an app garbage collect the routes it has added before, but doesn't need anymore,
+// such as routes of a previous version of the app.
+//
+// All the routes are deleted with a single write, guarded by the ETag of the servers unless IfMatch is in effect.
+func (caddyCfg *CaddyCfg) PruneOwnedRoutes(ctx context.Context, owner string, keep []string) (Plan, error) {
+	kept := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		kept[id] = true
+	}
+	return caddyCfg.pruneRoutes(ctx, func(route json.RawMessage) (string, bool) {
+		id, ok := ownedBy(route, owner)
+		return id, ok && !kept[id]
+	})
+}
+
+// pruneRoutes deletes the routes of all the servers for which prunable returns true along with the route "@id",
+// and returns the deletions made.
+//
+// All the routes are deleted with a single write, guarded by the ETag of the servers unless IfMatch is in effect.
+// If the servers change concurrently, the deletions are worked out anew.
+func (caddyCfg *CaddyCfg) pruneRoutes(ctx context.Context, prunable func(route json.RawMessage) (string, bool)) (Plan, error) {
+	var plan Plan
+	prune := func(current json.RawMessage) (json.RawMessage, error) {
+		plan = nil
+		var servers map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(current, &servers); err != nil {
+			return nil, err
+		}
+		serverKeys := make([]string, 0, len(servers))
+		for serverKey := range servers {
+			serverKeys = append(serverKeys, serverKey)
+		}
+		sort.Strings(serverKeys)
+
+		for _, serverKey := range serverKeys {
+			server := servers[serverKey]
+			var routes []json.RawMessage
+			if r, ok := server["routes"]; ok {
+				if err := json.Unmarshal(r, &routes); err != nil {
+					return nil, err
+				}
+			}
+			left := routes[:0:0]
+			for i, r := range routes {
+				if id, ok := prunable(r); ok {
+					plan = append(plan, RouteChange{Action: RouteDeleted, ServerKey: serverKey, RouteID: id, From: i, To: -1})
+					continue
+				}
+				left = append(left, r)
+			}
+			if len(left) == len(routes) {
+				continue
+			}
+			b, err := json.Marshal(left)
+			if err != nil {
+				return nil, err
+			}
+			server["routes"] = b
+		}
+		if len(plan) == 0 {
+			return nil, ErrNoChange
+		}
+		return json.Marshal(servers)
+	}
+
+	configURL := caddyCfg.pathURL("config", "apps", "http", "servers")
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = caddyCfg.modify(ctx, configURL, prune)
+		if errors.Is(err, ErrConflict) && caddyCfg.ifMatch == "" && attempt < maxConflictRetries {
+			continue
+		}
+		break
+	}
+	if errors.Is(err, ErrNoChange) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ownedBy returns "@id" of route if it's owned by owner.
+func ownedBy(route json.RawMessage, owner string) (string, bool) {
+	if owner == "" || routeMetaOf(route).Owner != owner {
+		return "", false
+	}
+	var f IDField
+	if json.Unmarshal(route, &f) != nil || f.Id == "" {
+		return "", false
+	}
+	return f.Id, true
+}
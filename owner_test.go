@@ -0,0 +1,108 @@
+package caddycfg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCaddyCfg_OwnedRoutes(t *testing.T) {
+	admin := newFakeAdmin(t, `{"apps":{"http":{"servers":{"a":{"routes":[]},"b":{"routes":[]}}}}}`)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	add := func(serverKey string, id string, opts ...RouteOption) {
+		t.Helper()
+		if err := caddyCfg.AddRoute(serverKey, id, ReverseProxyCaddyRouteConf(8080, []string{id}, "/*"), opts...); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	add("a", "app1-old", Owner("app1"))
+	add("a", "app2", Owner("app2"))
+	add("a", "app1", Owner("app1"), Priority(1))
+	add("b", "app1-other", Owner("app1"))
+	add("b", "nobody")
+
+	owned, err := caddyCfg.ListOwnedRoutes(ctx, "app1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []string
+	for _, r := range owned {
+		got = append(got, r.ServerKey+"/"+r.RouteID)
+	}
+	if want := []string{"a/app1-old", "a/app1", "b/app1-other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+	if owned[1].Index != 2 || routeMetaOf(owned[1].Config).Owner != "app1" {
+		t.Errorf("Unexpected %+v", owned[1])
+	}
+	if owned, err := caddyCfg.ListOwnedRoutes(ctx, ""); err != nil || len(owned) != 0 {
+		t.Errorf("Expected no routes without owner, got %v, %v", owned, err)
+	}
+
+	plan, err := caddyCfg.PruneOwnedRoutes(ctx, "app1", []string{"app1"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `delete route 'app1-old' from server 'a' at 0
+delete route 'app1-other' from server 'b' at 0`
+	if plan.String() != want {
+		t.Errorf("Plan error, want:\n%v\ngot:\n%v", want, plan)
+	}
+	if ids := routeIDs(t, admin, "a"); !reflect.DeepEqual(ids, []string{"app2", "app1"}) {
+		t.Errorf("Unexpected routes %v", ids)
+	}
+	if ids := routeIDs(t, admin, "b"); !reflect.DeepEqual(ids, []string{"nobody"}) {
+		t.Errorf("Unexpected routes %v", ids)
+	}
+
+	admin.served()
+	if plan, err := caddyCfg.PruneOwnedRoutes(ctx, "app1", []string{"app1"}); err != nil || len(plan) != 0 {
+		t.Errorf("Expected nothing to prune, got %v, %v", plan, err)
+	}
+	if served := admin.served(); len(served) != 1 {
+		t.Errorf("Expected a single GET, got %v", served)
+	}
+}
+
+func TestReconciler_Owner(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	route := func(id string) DesiredRoute {
+		return DesiredRoute{ID: id, Route: ReverseProxyCaddyRouteConf(8080, []string{id}, "/*")}
+	}
+	app1 := NewReconciler(caddyCfg, ReconcileOwner("app1"))
+	app2 := NewReconciler(caddyCfg, ReconcileOwner("app2"))
+
+	if _, err := app1.Reconcile(ctx, map[string][]DesiredRoute{"myserver": {route("a1"), route("a2")}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := app2.Reconcile(ctx, map[string][]DesiredRoute{"myserver": {route("b1")}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	plan, err := app1.Reconcile(ctx, map[string][]DesiredRoute{"myserver": {route("a2")}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `delete route 'a1' from server 'myserver' at 0`; plan.String() != want {
+		t.Errorf("Plan error, want:\n%v\ngot:\n%v", want, plan)
+	}
+	if ids := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(ids, []string{"a2", "b1"}) {
+		t.Errorf("Unexpected routes %v", ids)
+	}
+	owned, err := caddyCfg.ListOwnedRoutes(ctx, "app2")
+	if err != nil || len(owned) != 1 || owned[0].RouteID != "b1" {
+		t.Errorf("Unexpected routes of app2 %v, %v", owned, err)
+	}
+
+	// Routes of others are not taken over.
+	before := admin.config()
+	if _, err := app2.Reconcile(ctx, map[string][]DesiredRoute{"myserver": {route("a2"), route("b1")}}); !errors.Is(err, ErrRouteOwned) {
+		t.Errorf("Expected ErrRouteOwned, got %v", err)
+	}
+	if admin.config() != before {
+		t.Errorf("Unexpected change %v", admin.config())
+	}
+}
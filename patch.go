@@ -0,0 +1,437 @@
+package caddycfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a JSON Patch (RFC 6902) operation.
+//
+// JSONPatch produces "add", "remove" and "replace" operations only, which are the ones
+// AdminOperations can translate.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (p PatchOperation) String() string {
+	if p.Op == "remove" {
+		return fmt.Sprintf("%v %v", p.Op, p.Path)
+	}
+	return fmt.Sprintf("%v %v %s", p.Op, p.Path, p.Value)
+}
+
+// JSONPatch returns a JSON Patch (RFC 6902) turning JSON document current into desired,
+// such as two Caddy configurations. Equal documents make an empty patch.
+//
+// The patch changes only what differs, down to single fields, and array elements are matched along
+// their longest common subsequence, so that inserting a route in the middle of routes is a single "add".
+// Operations are to be applied in order, as array indices account for the operations before them.
+func JSONPatch(current, desired []byte) ([]PatchOperation, error) {
+	a, err := decodeJSON(current)
+	if err != nil {
+		return nil, fmt.Errorf("decoding current JSON: %w", err)
+	}
+	b, err := decodeJSON(desired)
+	if err != nil {
+		return nil, fmt.Errorf("decoding desired JSON: %w", err)
+	}
+	var patch []PatchOperation
+	makePatch(&patch, "", a, b)
+	return patch, nil
+}
+
+func makePatch(patch *[]PatchOperation, path string, a, b any) {
+	var o equalOptions
+	if o.equal(a, b, false) {
+		return
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				p := path + "/" + escapePointer(k)
+				va, inA := a[k]
+				vb, inB := b[k]
+				switch {
+				case !inB:
+					*patch = append(*patch, PatchOperation{Op: "remove", Path: p})
+				case !inA:
+					*patch = append(*patch, PatchOperation{Op: "add", Path: p, Value: encodeJSON(vb)})
+				default:
+					makePatch(patch, p, va, vb)
+				}
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			makeArrayPatch(patch, path, a, b)
+			return
+		}
+	}
+	*patch = append(*patch, PatchOperation{Op: "replace", Path: path, Value: encodeJSON(b)})
+}
+
+func makeArrayPatch(patch *[]PatchOperation, path string, a, b []any) {
+	var o equalOptions
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case o.equal(a[i], b[j], false):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// k is the index in the array being patched, which has length elements at the moment.
+	k, length := 0, len(a)
+	var removed, added []int
+	flush := func() {
+		for x := range removed {
+			if x < len(added) {
+				makePatch(patch, fmt.Sprintf("%v/%d", path, k), a[removed[x]], b[added[x]])
+				k++
+			} else {
+				*patch = append(*patch, PatchOperation{Op: "remove", Path: fmt.Sprintf("%v/%d", path, k)})
+				length--
+			}
+		}
+		for x := len(removed); x < len(added); x++ {
+			p := fmt.Sprintf("%v/%d", path, k)
+			if k == length {
+				p = path + "/-"
+			}
+			*patch = append(*patch, PatchOperation{Op: "add", Path: p, Value: encodeJSON(b[added[x]])})
+			k++
+			length++
+		}
+		removed, added = removed[:0], added[:0]
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && o.equal(a[i], b[j], false):
+			flush()
+			i++
+			j++
+			k++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+}
+
+// AdminOperation is a request to Caddy's admin API changing the configuration under "/config/".
+type AdminOperation struct {
+	// Method is one of PATCH (replace), PUT (create or insert into an array), POST (append to an array
+	// or set the whole configuration) and DELETE.
+	Method string
+	// Path is the configuration path under "/config/", one segment per element.
+	Path []string
+	// Body is the JSON sent along, if any.
+	Body json.RawMessage
+}
+
+func (o AdminOperation) String() string {
+	p := "/config/" + strings.Join(o.Path, "/")
+	if len(o.Body) == 0 {
+		return fmt.Sprintf("%v %v", o.Method, p)
+	}
+	return fmt.Sprintf("%v %v %s", o.Method, p, o.Body)
+}
+
+// ErrUnsupportedPatch is returned for JSON Patch operations that have no Caddy admin API counterpart,
+// such as "move", "copy" and "test".
+var ErrUnsupportedPatch = errors.New("unsupported JSON Patch operation")
+
+// AdminOperations translates patch made by JSONPatch into the Caddy admin API requests making the same changes:
+// "replace" becomes PATCH, "add" becomes PUT, or POST for appending with "-", and "remove" becomes DELETE.
+// Changes of the whole configuration become POST or DELETE of "/config/".
+//
+// Since array elements can only be appended with POST, an "add" at the index past the last element
+// has to use "-", the way JSONPatch does.
+func AdminOperations(patch []PatchOperation) ([]AdminOperation, error) {
+	ops := make([]AdminOperation, 0, len(patch))
+	for _, p := range patch {
+		op, err := adminOperation(p)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func adminOperation(p PatchOperation) (AdminOperation, error) {
+	tokens, err := parsePointer(p.Path)
+	if err != nil {
+		return AdminOperation{}, err
+	}
+	switch {
+	case p.Op == "replace" && len(tokens) == 0:
+		return AdminOperation{Method: http.MethodPost, Body: p.Value}, nil
+	case p.Op == "replace":
+		return AdminOperation{Method: http.MethodPatch, Path: tokens, Body: p.Value}, nil
+	case p.Op == "add" && len(tokens) == 0:
+		return AdminOperation{Method: http.MethodPost, Body: p.Value}, nil
+	case p.Op == "add" && tokens[len(tokens)-1] == "-":
+		return AdminOperation{Method: http.MethodPost, Path: tokens[:len(tokens)-1], Body: p.Value}, nil
+	case p.Op == "add":
+		return AdminOperation{Method: http.MethodPut, Path: tokens, Body: p.Value}, nil
+	case p.Op == "remove":
+		return AdminOperation{Method: http.MethodDelete, Path: tokens}, nil
+	}
+	return AdminOperation{}, fmt.Errorf("%w: %q", ErrUnsupportedPatch, p.Op)
+}
+
+// parsePointer splits JSON Pointer p into unescaped reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for i, t := range tokens {
+		tokens[i] = unescape.Replace(t)
+	}
+	return tokens, nil
+}
+
+// ApplyPatch makes the changes of patch, computed with JSONPatch against configuration base,
+// to Caddy's configuration with the requests of AdminOperations, one by one.
+//
+// Every request is guarded: the configuration section it changes is first checked to be what the patch expects
+// given base and the requests before, and the request is sent with the ETag of that section, so that
+// a concurrent change makes ApplyPatch stop with an error matching errors.Is(err, ErrConflict) rather than
+// mess the configuration up. The requests made before the conflict stay in effect.
+//
+// When IfMatch is in effect, its ETag guards the first request instead, so that nothing changes unless
+// the configuration is still the one the ETag was obtained for.
+func (caddyCfg *CaddyCfg) ApplyPatch(ctx context.Context, base []byte, patch []PatchOperation) error {
+	_, err := caddyCfg.applyPatch(ctx, base, patch)
+	return err
+}
+
+// applyPatch is ApplyPatch returning the requests it has made.
+func (caddyCfg *CaddyCfg) applyPatch(ctx context.Context, base []byte, patch []PatchOperation) ([]AdminOperation, error) {
+	doc, err := decodeJSON(base)
+	if err != nil {
+		return nil, fmt.Errorf("decoding base JSON: %w", err)
+	}
+	ops, err := AdminOperations(patch)
+	if err != nil {
+		return nil, err
+	}
+	var made []AdminOperation
+	for i, op := range ops {
+		// The section the request changes: the parent of its path, or the array it appends to.
+		guarded := op.Path
+		if op.Method != http.MethodPost && len(guarded) > 0 {
+			guarded = guarded[:len(guarded)-1]
+		}
+		expected, err := lookupPointer(doc, guarded)
+		if err != nil {
+			return made, err
+		}
+		live, etag, err := caddyCfg.send(ctx, caddyCfg.httpClient, http.MethodGet, caddyCfg.pathURL("config", guarded...), nil)
+		if err != nil {
+			return made, err
+		}
+		if !EqualJSON(live, encodeJSON(expected)) {
+			return made, fmt.Errorf("%w: /config/%v has changed concurrently", ErrConflict, strings.Join(guarded, "/"))
+		}
+		var body io.Reader
+		if op.Body != nil {
+			body = bytes.NewReader(op.Body)
+		}
+		if i == 0 && caddyCfg.ifMatch != "" {
+			// The requests after the first one change what the ETag of IfMatch was obtained for.
+			etag = caddyCfg.ifMatch
+		}
+		if _, _, err := caddyCfg.IfMatch(etag).send(ctx, caddyCfg.httpClient, op.Method, caddyCfg.pathURL("config", op.Path...), body); err != nil {
+			return made, err
+		}
+		made = append(made, op)
+		if doc, err = applyPatchOperation(doc, patch[i]); err != nil {
+			return made, err
+		}
+	}
+	return made, nil
+}
+
+// UploadIncremental brings Caddy's configuration to configJSON the way Upload does, but instead of
+// replacing the whole configuration, it makes only the changes found with JSONPatch, using ApplyPatch.
+// If the configuration changes concurrently, the changes are worked out anew, unless IfMatch is in effect,
+// in which case a conflict is returned without retrying, see ApplyPatch.
+// It returns the requests it has made, none if the configuration is already the same.
+//
+// Every request makes Caddy reload, so this is best for small changes to big configurations.
+// Caddy has to accept every intermediate configuration.
+//
+// The marker of Watch in the "@id" of the configuration root is kept unless configJSON has an "@id" of its own,
+// as the configuration is changed rather than replaced.
+func (caddyCfg *CaddyCfg) UploadIncremental(ctx context.Context, configJSON string) ([]AdminOperation, error) {
+	var made []AdminOperation
+	for attempt := 1; ; attempt++ {
+		current, _, err := caddyCfg.ConfigWithETag(ctx)
+		if err != nil {
+			return made, err
+		}
+		patch, err := JSONPatch([]byte(current), []byte(configJSON))
+		if err != nil {
+			return made, err
+		}
+		patch = keepMarker(patch)
+		ops, err := caddyCfg.applyPatch(ctx, []byte(current), patch)
+		made = append(made, ops...)
+		if errors.Is(err, ErrConflict) && caddyCfg.ifMatch == "" && attempt < maxConflictRetries {
+			continue
+		}
+		return made, err
+	}
+}
+
+// keepMarker drops the removal of the marker of Watch from patch.
+func keepMarker(patch []PatchOperation) []PatchOperation {
+	kept := patch[:0]
+	for _, op := range patch {
+		if op.Op == "remove" && op.Path == "/"+escapePointer(markerPath) {
+			continue
+		}
+		kept = append(kept, op)
+	}
+	return kept
+}
+
+// lookupPointer returns the value of doc under tokens.
+func lookupPointer(doc any, tokens []string) (any, error) {
+	v := doc
+	for _, t := range tokens {
+		switch vv := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = vv[t]; !ok {
+				return nil, fmt.Errorf("no key %q in JSON", t)
+			}
+		case []any:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(vv) {
+				return nil, fmt.Errorf("invalid array index %q in JSON", t)
+			}
+			v = vv[i]
+		default:
+			return nil, fmt.Errorf("no %q in JSON", t)
+		}
+	}
+	return v, nil
+}
+
+// applyPatchOperation applies "add", "remove" or "replace" p to doc and returns the changed doc.
+func applyPatchOperation(doc any, p PatchOperation) (any, error) {
+	tokens, err := parsePointer(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if p.Op != "remove" {
+		if value, err = decodeJSON(p.Value); err != nil {
+			return nil, err
+		}
+	}
+	return patchValue(doc, tokens, p.Op, value)
+}
+
+// patchValue applies op with value to v under tokens and returns the changed v.
+func patchValue(v any, tokens []string, op string, value any) (any, error) {
+	if op != "add" && op != "remove" && op != "replace" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPatch, op)
+	}
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+		return value, nil
+	}
+	t, last := tokens[0], len(tokens) == 1
+	switch v := v.(type) {
+	case map[string]any:
+		child, ok := v[t]
+		switch {
+		case last && op == "add":
+			v[t] = value
+		case !ok:
+			return nil, fmt.Errorf("no key %q in JSON", t)
+		case last && op == "remove":
+			delete(v, t)
+		default:
+			child, err := patchValue(child, tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			v[t] = child
+		}
+		return v, nil
+	case []any:
+		i := len(v)
+		if t != "-" {
+			var err error
+			if i, err = strconv.Atoi(t); err != nil || i < 0 || i > len(v) {
+				return nil, fmt.Errorf("invalid array index %q in JSON", t)
+			}
+		}
+		switch {
+		case last && op == "add":
+			v = append(v, nil)
+			copy(v[i+1:], v[i:])
+			v[i] = value
+		case i == len(v):
+			return nil, fmt.Errorf("invalid array index %q in JSON", t)
+		case last && op == "remove":
+			v = append(v[:i], v[i+1:]...)
+		default:
+			child, err := patchValue(v[i], tokens[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = child
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("no %q in JSON", t)
+}
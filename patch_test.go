@@ -0,0 +1,264 @@
+package caddycfg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONPatch(t *testing.T) {
+	for _, tt := range []struct {
+		current, desired string
+		want             []string
+	}{
+		{`{"a":1}`, `{"a":1}`, nil},
+		{`{"a":1,"b":{"c":[1,2]}}`, `{"b":{"c":[1,2],"d":"x"},"e":null}`, []string{
+			`remove /a`,
+			`add /b/d "x"`,
+			`add /e null`,
+		}},
+		{`{"a":1}`, `[1]`, []string{`replace  [1]`}},
+		{`{"a~/b":1}`, `{"a~/b":2}`, []string{`replace /a~0~1b 2`}},
+		{`[1,2,3]`, `[1,9,2,3,4]`, []string{`add /1 9`, `add /- 4`}},
+		{`[1,2,3,4]`, `[2,4]`, []string{`remove /0`, `remove /1`}},
+		{`[1,2,3]`, `[3,2,1]`, []string{`remove /0`, `remove /0`, `add /- 2`, `add /- 1`}},
+		{`[{"a":1},5,{"b":2}]`, `[{"a":2},{"b":2},7]`, []string{`replace /0/a 2`, `remove /1`, `add /- 7`}},
+	} {
+		patch, err := JSONPatch([]byte(tt.current), []byte(tt.desired))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var got []string
+		for _, p := range patch {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("JSONPatch(%v, %v) error, want:\n%q\ngot:\n%q", tt.current, tt.desired, tt.want, got)
+		}
+		doc, err := decodeJSON([]byte(tt.current))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, p := range patch {
+			if doc, err = applyPatchOperation(doc, p); err != nil {
+				t.Fatalf("Applying %v to %v: %v", p, tt.current, err)
+			}
+		}
+		if !EqualJSON(encodeJSON(doc), []byte(tt.desired)) {
+			t.Errorf("Patch of %v makes %s rather than %v", tt.current, encodeJSON(doc), tt.desired)
+		}
+	}
+
+	if _, err := JSONPatch([]byte(`{`), []byte(`{}`)); err == nil {
+		t.Errorf("Expected error for invalid JSON")
+	}
+}
+
+func TestAdminOperations(t *testing.T) {
+	ops, err := AdminOperations([]PatchOperation{
+		{Op: "replace", Path: "/apps/http/servers/my~1server/listen/0", Value: []byte(`":8443"`)},
+		{Op: "add", Path: "/apps/http/servers/my~1server/routes/1", Value: []byte(`{}`)},
+		{Op: "add", Path: "/apps/http/servers/my~1server/routes/-", Value: []byte(`{}`)},
+		{Op: "add", Path: "/admin", Value: []byte(`{}`)},
+		{Op: "remove", Path: "/apps/tls"},
+		{Op: "replace", Path: "", Value: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []string
+	for _, op := range ops {
+		got = append(got, op.String())
+	}
+	want := []string{
+		`PATCH /config/apps/http/servers/my/server/listen/0 ":8443"`,
+		`PUT /config/apps/http/servers/my/server/routes/1 {}`,
+		`POST /config/apps/http/servers/my/server/routes {}`,
+		`PUT /config/admin {}`,
+		`DELETE /config/apps/tls`,
+		`POST /config/ {}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AdminOperations error, want:\n%q\ngot:\n%q", want, got)
+	}
+	if ops[0].Path[3] != "my/server" {
+		t.Errorf("Expected unescaped path segment, got %q", ops[0].Path)
+	}
+
+	if _, err := AdminOperations([]PatchOperation{{Op: "move", Path: "/a"}}); !errors.Is(err, ErrUnsupportedPatch) {
+		t.Errorf("Expected ErrUnsupportedPatch, got %v", err)
+	}
+}
+
+func TestCaddyCfg_UploadIncremental(t *testing.T) {
+	current := `{"apps":{"http":{"servers":{"myserver":{"listen":[":443"],"routes":[
+		{"@id":"a","handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:8080"}]}]},
+		{"@id":"b","handle":[{"handler":"static_response","body":"b"}]}
+	]}}}}}`
+	desired := `{"apps":{"http":{"servers":{"myserver":{"listen":[":443"],"routes":[
+		{"@id":"a","handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"localhost:9000"}]}]},
+		{"@id":"new","handle":[{"handler":"static_response","body":"new"}]},
+		{"@id":"b","handle":[{"handler":"static_response","body":"b"}]}
+	]}}}}}`
+	admin := newFakeAdmin(t, current)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	ops, err := caddyCfg.UploadIncremental(ctx, desired)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ops) != 2 {
+		t.Errorf("Expected 2 operations, got %v", ops)
+	}
+	if !EqualJSON([]byte(admin.config()), []byte(desired)) {
+		t.Errorf("Unexpected config %v", admin.config())
+	}
+	want := []string{
+		"GET /config/",
+		"GET /config/apps/http/servers/myserver/routes/0/handle/0/upstreams/0",
+		"PATCH /config/apps/http/servers/myserver/routes/0/handle/0/upstreams/0/dial",
+		"GET /config/apps/http/servers/myserver/routes",
+		"PUT /config/apps/http/servers/myserver/routes/1",
+	}
+	if served := admin.served(); !reflect.DeepEqual(served, want) {
+		t.Errorf("Unexpected requests, want:\n%q\ngot:\n%q", want, served)
+	}
+
+	ops, err = caddyCfg.UploadIncremental(ctx, desired)
+	if err != nil || len(ops) != 0 {
+		t.Errorf("Expected no operations, got %v, %v", ops, err)
+	}
+}
+
+func TestCaddyCfg_UploadIncremental_IfMatch(t *testing.T) {
+	config := `{"apps":{"http":{"servers":{"myserver":{"listen":[":443"],"routes":[
+		{"@id":"a","handle":[{"handler":"static_response","body":"a"}]}
+	]}}}}}`
+	admin := newFakeAdmin(t, config)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	_, etag, err := caddyCfg.ConfigWithETag(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	desired := strings.Replace(config, `"body":"a"`, `"body":"aa"`, 1)
+
+	// The configuration changes after the ETag was obtained.
+	if err := caddyCfg.Set(ctx, []string{":8443"}, "apps", "http", "servers", "myserver", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	admin.served()
+	ops, err := caddyCfg.IfMatch(etag).UploadIncremental(ctx, desired)
+	if !errors.Is(err, ErrConflict) || len(ops) != 0 {
+		t.Errorf("Expected conflict, got %v, %v", ops, err)
+	}
+	if served := admin.served(); len(served) != 3 {
+		t.Errorf("Expected a single attempt, got %v", served)
+	}
+	if !strings.Contains(admin.config(), `"body":"a"`) {
+		t.Errorf("Unexpected change %v", admin.config())
+	}
+
+	// The ETag of the configuration as it is lets the changes through.
+	_, etag, err = caddyCfg.ConfigWithETag(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := caddyCfg.IfMatch(etag).UploadIncremental(ctx, strings.Replace(desired, `":443"`, `":8443"`, 1)); err != nil {
+		t.Errorf("%v", err)
+	}
+	if !strings.Contains(admin.config(), `"body":"aa"`) {
+		t.Errorf("Expected the change to be made, got %v", admin.config())
+	}
+}
+
+func TestCaddyCfg_UploadIncremental_Watch(t *testing.T) {
+	config := BaseConfig("localhost:2019", "myserver")
+	admin := newFakeAdmin(t, config)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resets := make(chan struct{}, 10)
+	var polls watchPolls
+	go func() {
+		_ = NewCaddyCfg(admin.URL, WithTransport(polls.transport())).Watch(ctx, func(ctx context.Context) error {
+			resets <- struct{}{}
+			return nil
+		}, WatchInterval(time.Millisecond))
+	}()
+	select {
+	case <-resets:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No reset at start")
+	}
+	var marker string
+	if err := caddyCfg.Get(ctx, &marker, markerPath); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ops, err := caddyCfg.UploadIncremental(ctx, strings.Replace(config, `":443"`, `":8443"`, 1))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ops) == 0 || !strings.Contains(admin.config(), `":8443"`) {
+		t.Errorf("Expected the change to be made, got %v", ops)
+	}
+	for _, op := range ops {
+		if op.Method == http.MethodDelete {
+			t.Errorf("Unexpected operation %v", op)
+		}
+	}
+	polls.wait(t, 3)
+	select {
+	case <-resets:
+		t.Errorf("Unexpected reset after an incremental upload")
+	default:
+	}
+	var kept string
+	if err := caddyCfg.Get(ctx, &kept, markerPath); err != nil || kept != marker {
+		t.Errorf("Expected marker %q to be kept, got %q, %v", marker, kept, err)
+	}
+}
+
+func TestCaddyCfg_ApplyPatch(t *testing.T) {
+	base := `{"apps":{"http":{"servers":{"myserver":{"listen":[":443"],"routes":[
+		{"@id":"a","handle":[{"handler":"static_response","body":"a"}]}
+	]}}}}}`
+	admin := newFakeAdmin(t, base)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+
+	patch, err := JSONPatch([]byte(base), []byte(strings.Replace(base, `"body":"a"`, `"body":"aa"`, 1)))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The route changes after the patch was made.
+	if err := caddyCfg.Set(ctx, "concurrent", "apps", "http", "servers", "myserver", "routes", "0", "handle", "0", "body"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.ApplyPatch(ctx, []byte(base), patch); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+	if !strings.Contains(admin.config(), `"body":"concurrent"`) {
+		t.Errorf("Expected concurrent change to stay, got %v", admin.config())
+	}
+
+	// Changes elsewhere don't matter.
+	if err := caddyCfg.Set(ctx, "a", "apps", "http", "servers", "myserver", "routes", "0", "handle", "0", "body"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.Set(ctx, []string{":8443"}, "apps", "http", "servers", "myserver", "listen"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := caddyCfg.ApplyPatch(ctx, []byte(base), patch); err != nil {
+		t.Errorf("%v", err)
+	}
+	if config := admin.config(); !strings.Contains(config, `"body":"aa"`) || !strings.Contains(config, `":8443"`) {
+		t.Errorf("Unexpected config %v", config)
+	}
+}
@@ -0,0 +1,132 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrRouteNotFound is what a *RouteError unwraps to when the route passed to Before or After
+// is not among the routes of the server.
+var ErrRouteNotFound = errors.New("route not found")
+
+// placement returns the index a route has to be put at among routes of a server, which don't include the route itself.
+// current is the index the route used to be at, or -1 if it's a new one.
+type placement func(routes []json.RawMessage, current int) (int, error)
+
+func placeBefore(id string) placement {
+	return func(routes []json.RawMessage, _ int) (int, error) {
+		i := routeIndex(routes, id)
+		if i < 0 {
+			return 0, fmt.Errorf("%w: '%v'", ErrRouteNotFound, id)
+		}
+		return i, nil
+	}
+}
+
+func placeAfter(id string) placement {
+	return func(routes []json.RawMessage, _ int) (int, error) {
+		i := routeIndex(routes, id)
+		if i < 0 {
+			return 0, fmt.Errorf("%w: '%v'", ErrRouteNotFound, id)
+		}
+		return i + 1, nil
+	}
+}
+
+func placeAt(index int) placement {
+	return func(routes []json.RawMessage, _ int) (int, error) {
+		switch {
+		case index < 0:
+			return 0, nil
+		case index > len(routes):
+			return len(routes), nil
+		}
+		return index, nil
+	}
+}
+
+// placeByPriority keeps routes with higher priority before the ones with lower priority.
+// Routes with no priority don't take part in ordering.
+//
+// A route that already is in a suitable position stays there. Otherwise it goes right after the last route
+// with the same or higher priority, or, if there's none, right before the first route with lower priority.
+// With no prioritized routes at all, the route goes to the end.
+func placeByPriority(priority int) placement {
+	return func(routes []json.RawMessage, current int) (int, error) {
+		lo, hi, after := 0, len(routes), -1
+		for i, r := range routes {
+			p := routeMetaOf(r).Priority
+			if p == nil {
+				continue
+			}
+			if *p > priority {
+				lo = i + 1
+			}
+			if *p < priority && hi == len(routes) {
+				hi = i
+			}
+			if *p >= priority {
+				after = i + 1
+			}
+		}
+		if current >= lo && current <= hi {
+			return current, nil
+		}
+		if after >= 0 {
+			return after, nil
+		}
+		return hi, nil
+	}
+}
+
+// routeIndex returns the index of the route with "@id" id among routes, or -1.
+func routeIndex(routes []json.RawMessage, id string) int {
+	for i, r := range routes {
+		var f IDField
+		if json.Unmarshal(r, &f) == nil && f.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// placeRoute makes a single attempt to put cfg under routeId among the routes of serverKey at the position
+// chosen by place, moving the route if it's elsewhere. It returns the configuration it has replaced or moved, if any.
+//
+// All the routes of the server are replaced at once, guarded by their ETag unless IfMatch is in effect,
+// so that the route never disappears and concurrent changes are reported as ErrConflict.
+func (caddyCfg *CaddyCfg) placeRoute(ctx context.Context, serverKey string, routeId string, cfg string, place placement) (string, error) {
+	var replaced json.RawMessage
+	configURL := caddyCfg.pathURL("config", "apps", "http", "servers", serverKey, "routes")
+	err := caddyCfg.modify(ctx, configURL, func(current json.RawMessage) (json.RawMessage, error) {
+		var routes []json.RawMessage
+		if err := json.Unmarshal(current, &routes); err != nil {
+			return nil, err
+		}
+		i := routeIndex(routes, routeId)
+		var existing json.RawMessage
+		if i >= 0 {
+			existing = routes[i]
+			routes = append(routes[:i:i], routes[i+1:]...)
+		}
+		at, err := place(routes, i)
+		if err != nil {
+			return nil, err
+		}
+		if at == i && sameRoute(cfg, string(existing)) {
+			return nil, ErrNoChange
+		}
+		replaced = existing
+		routes = append(routes[:at:at], append([]json.RawMessage{json.RawMessage(cfg)}, routes[at:]...)...)
+		return json.Marshal(routes)
+	})
+	if errors.Is(err, ErrNoChange) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(replaced), nil
+}
@@ -0,0 +1,141 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// routeIDs returns "@id"s of the routes of serverKey in order.
+func routeIDs(t *testing.T, admin *fakeAdmin, serverKey string) []string {
+	t.Helper()
+	var config struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]struct {
+					Routes []IDField `json:"routes"`
+				} `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal([]byte(admin.config()), &config); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ids := []string{}
+	for _, r := range config.Apps.HTTP.Servers[serverKey].Routes {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+func TestCaddyCfg_AddRoute_Position(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	route := func(path string) *caddyhttp.Route {
+		return ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, path)
+	}
+
+	steps := []struct {
+		id   string
+		path string
+		opts []RouteOption
+		want []string
+	}{
+		{"a", "/*", nil, []string{"a"}},
+		{"b", "/*", []RouteOption{Before("a")}, []string{"b", "a"}},
+		{"c", "/*", []RouteOption{After("b")}, []string{"b", "c", "a"}},
+		{"d", "/*", []RouteOption{AtIndex(0)}, []string{"d", "b", "c", "a"}},
+		{"e", "/*", []RouteOption{AtIndex(100)}, []string{"d", "b", "c", "a", "e"}},
+		// Moves existing routes.
+		{"d", "/*", []RouteOption{After("a")}, []string{"b", "c", "a", "d", "e"}},
+		{"b", "/b/*", []RouteOption{AtIndex(-1)}, []string{"b", "c", "a", "d", "e"}},
+	}
+	for _, s := range steps {
+		if err := caddyCfg.AddRoute("myserver", s.id, route(s.path), s.opts...); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("Adding %v: want %v, got %v", s.id, s.want, got)
+		}
+	}
+
+	err := caddyCfg.AddRoute("myserver", "f", route("/*"), Before("g"))
+	var routeErr *RouteError
+	if !errors.As(err, &routeErr) || !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("Expected *RouteError with ErrRouteNotFound, got %v", err)
+	}
+
+	// Nothing to change.
+	admin.served()
+	if err := caddyCfg.AddRoute("myserver", "a", route("/*"), After("c")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if served := admin.served(); len(served) != 1 || !strings.HasPrefix(served[0], "GET ") {
+		t.Errorf("Expected a single GET, got %v", served)
+	}
+}
+
+func TestCaddyCfg_AddRoute_Priority(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	add := func(id string, path string, opts ...RouteOption) {
+		t.Helper()
+		if err := caddyCfg.AddRoute("myserver", id, ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, path), opts...); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	byPath := func(path string) RouteOption {
+		return Priority(len(path))
+	}
+
+	add("unmanaged", "/static/*")
+	add("all", "/*", byPath("/*"))
+	add("api", "/api/*", byPath("/api/*"))
+	add("api-v1", "/api/v1/*", byPath("/api/v1/*"))
+	add("app", "/app/*", byPath("/app/*"))
+	want := []string{"unmanaged", "api-v1", "api", "app", "all"}
+	if got := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+
+	// Still in order, stays in place.
+	add("api", "/api/*", Priority(6))
+	if got := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+	// Out of order, moves.
+	add("all", "/*", Priority(100))
+	want = []string{"unmanaged", "all", "api-v1", "api", "app"}
+	if got := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want %v, got %v", want, got)
+	}
+
+	// Priority is kept in the route, and a change of it alone is applied.
+	admin.served()
+	add("all", "/*", Priority(99))
+	if served := admin.served(); len(served) != 2 {
+		t.Errorf("Expected GET and PATCH, got %v", served)
+	}
+	all, err := GetByID[json.RawMessage](context.Background(), caddyCfg, "all")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if p := routeMetaOf(all).Priority; p == nil || *p != 99 {
+		t.Errorf("Unexpected priority in %s", all)
+	}
+
+	// Dropping priority removes it from the route.
+	add("all", "/*")
+	all, err = GetByID[json.RawMessage](context.Background(), caddyCfg, "all")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if strings.Contains(string(all), metaVar) {
+		t.Errorf("Unexpected metadata in %s", all)
+	}
+}
@@ -0,0 +1,229 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+)
+
+// ErrInvalidUpstream is what the error of LoadBalancedRouteConf unwraps to when an upstream address
+// can't be dialed by Caddy, or there are no upstreams.
+var ErrInvalidUpstream = errors.New("invalid upstream")
+
+// ProxyOption tweaks the "reverse_proxy" handler made by ReverseProxyCaddyRouteConf or LoadBalancedRouteConf.
+// Custom options may set any field of the handler.
+type ProxyOption func(*reverseproxy.Handler)
+
+// loadBalancing returns h.LoadBalancing, creating it if needed.
+func loadBalancing(h *reverseproxy.Handler) *reverseproxy.LoadBalancing {
+	if h.LoadBalancing == nil {
+		h.LoadBalancing = &reverseproxy.LoadBalancing{}
+	}
+	return h.LoadBalancing
+}
+
+func selectionPolicy(policy caddy.Module, name string) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		loadBalancing(h).SelectionPolicyRaw = caddyconfig.JSONModuleObject(policy, "policy", name, nil)
+	}
+}
+
+// SelectRoundRobin makes the proxy take upstreams in turns. Default is a random upstream.
+func SelectRoundRobin() ProxyOption {
+	return selectionPolicy(reverseproxy.RoundRobinSelection{}, "round_robin")
+}
+
+// SelectLeastConn makes the proxy take the upstream with the fewest active requests.
+func SelectLeastConn() ProxyOption {
+	return selectionPolicy(reverseproxy.LeastConnSelection{}, "least_conn")
+}
+
+// SelectIPHash makes the proxy take the upstream by the hash of the client IP,
+// so that a client sticks to the same upstream while it's available.
+func SelectIPHash() ProxyOption {
+	return selectionPolicy(reverseproxy.IPHashSelection{}, "ip_hash")
+}
+
+// SelectHeader makes the proxy take the upstream by the hash of the request header field.
+// Requests without the header get a random upstream.
+func SelectHeader(field string) ProxyOption {
+	return selectionPolicy(reverseproxy.HeaderHashSelection{Field: field}, "header")
+}
+
+// SelectCookie makes the proxy stick clients to upstreams with cookie name, "lb" if empty,
+// holding the upstream hashed with secret, which may be empty.
+func SelectCookie(name string, secret string) ProxyOption {
+	return selectionPolicy(reverseproxy.CookieHashSelection{Name: name, Secret: secret}, "cookie")
+}
+
+// TryDuration makes the proxy keep looking for an available upstream for up to d if the one it took is down.
+// Default is no retries.
+func TryDuration(d time.Duration) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		loadBalancing(h).TryDuration = caddy.Duration(d)
+	}
+}
+
+// TryInterval sets how long the proxy waits before taking the next upstream while retrying.
+// Caddy's default is 250ms if TryDuration is set.
+func TryInterval(d time.Duration) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		loadBalancing(h).TryInterval = caddy.Duration(d)
+	}
+}
+
+// Retries makes the proxy take up to n more upstreams if the one it took is down.
+// Along with TryDuration, whichever runs out first stops the retries.
+func Retries(n int) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		loadBalancing(h).Retries = n
+	}
+}
+
+// SRVUpstreams makes the proxy get its upstreams from the DNS SRV records of "_service._proto.name",
+// or just name if service and proto are empty, looked up again every refresh, or every minute if zero.
+// Dynamic upstreams take the place of the upstream addresses passed to LoadBalancedRouteConf, which then should be empty.
+func SRVUpstreams(service string, proto string, name string, refresh time.Duration) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		h.DynamicUpstreamsRaw = caddyconfig.JSONModuleObject(reverseproxy.SRVUpstreams{
+			Service: service,
+			Proto:   proto,
+			Name:    name,
+			Refresh: caddy.Duration(refresh),
+		}, "source", "srv", nil)
+	}
+}
+
+// AUpstreams makes the proxy get its upstreams from the DNS A/AAAA records of name, dialing them at port,
+// looked up again every refresh, or every minute if zero.
+// Dynamic upstreams take the place of the upstream addresses passed to LoadBalancedRouteConf, which then should be empty.
+func AUpstreams(name string, port string, refresh time.Duration) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		h.DynamicUpstreamsRaw = caddyconfig.JSONModuleObject(reverseproxy.AUpstreams{
+			Name:    name,
+			Port:    port,
+			Refresh: caddy.Duration(refresh),
+		}, "source", "a", nil)
+	}
+}
+
+// ActiveHealthCheck describes requests the proxy sends to every upstream in the background
+// to take the ones that fail out of rotation until they pass again.
+type ActiveHealthCheck struct {
+	// URI is the path and query of the requests, such as "/health".
+	URI string
+	// Port is the port of the requests, if other than the port of the upstream.
+	Port int
+	// Interval is how often the requests are sent. Caddy's default is 30s.
+	Interval time.Duration
+	// Timeout is how long a request may take before the upstream is deemed unhealthy. Caddy's default is 5s.
+	Timeout time.Duration
+	// ExpectStatus is the expected status code of the responses. Default is any 2xx.
+	ExpectStatus int
+	// ExpectBody is a regular expression the response bodies have to match.
+	ExpectBody string
+	// Headers are set on the requests, such as "Host".
+	Headers http.Header
+}
+
+// ActiveHealthChecks makes the proxy check the health of upstreams with requests described by check.
+func ActiveHealthChecks(check ActiveHealthCheck) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		healthChecks(h).Active = &reverseproxy.ActiveHealthChecks{
+			URI:          check.URI,
+			Port:         check.Port,
+			Headers:      check.Headers,
+			Interval:     caddy.Duration(check.Interval),
+			Timeout:      caddy.Duration(check.Timeout),
+			ExpectStatus: check.ExpectStatus,
+			ExpectBody:   check.ExpectBody,
+		}
+	}
+}
+
+// PassiveHealthCheck describes how the proxy judges the health of upstreams by the requests it proxies.
+type PassiveHealthCheck struct {
+	// FailDuration is how long a failed request counts. It has to be set to enable passive health checks.
+	FailDuration time.Duration
+	// MaxFails is how many failed requests within FailDuration make the upstream unhealthy. Caddy's default is 1.
+	MaxFails int
+	// UnhealthyStatus are status codes of responses that count as failed requests.
+	UnhealthyStatus []int
+	// UnhealthyLatency is how long a response may take before it counts as a failed request.
+	UnhealthyLatency time.Duration
+}
+
+// PassiveHealthChecks makes the proxy take upstreams out of rotation when proxied requests fail as described by check.
+func PassiveHealthChecks(check PassiveHealthCheck) ProxyOption {
+	return func(h *reverseproxy.Handler) {
+		healthChecks(h).Passive = &reverseproxy.PassiveHealthChecks{
+			FailDuration:     caddy.Duration(check.FailDuration),
+			MaxFails:         check.MaxFails,
+			UnhealthyStatus:  check.UnhealthyStatus,
+			UnhealthyLatency: caddy.Duration(check.UnhealthyLatency),
+		}
+	}
+}
+
+// healthChecks returns h.HealthChecks, creating it if needed.
+func healthChecks(h *reverseproxy.Handler) *reverseproxy.HealthChecks {
+	if h.HealthChecks == nil {
+		h.HealthChecks = &reverseproxy.HealthChecks{}
+	}
+	return h.HealthChecks
+}
+
+// LoadBalancedRouteConf generates a "routes" element configuration structure the way ReverseProxyCaddyRouteConf does,
+// but the requests are spread over upstreams, such as several replicas of a service:
+//
+//	route, err := LoadBalancedRouteConf(
+//		[]string{"10.0.0.1:8080", "10.0.0.2:8080", "unix//run/app.sock"},
+//		[]string{"example.com"}, "/*",
+//		SelectLeastConn(), TryDuration(5*time.Second),
+//	)
+//
+// Every upstream is an address Caddy can dial: "host:port", or "unix//path" for a unix socket.
+// upstreams may be empty if SRVUpstreams or AUpstreams is used instead.
+func LoadBalancedRouteConf(upstreams []string, matchHosts []string, pathMatch string, opts ...ProxyOption) (*caddyhttp.Route, error) {
+	handler := reverseproxy.Handler{
+		TransportRaw: caddyconfig.JSONModuleObject(reverseproxy.HTTPTransport{}, "protocol", "http", nil),
+	}
+	for _, upstream := range upstreams {
+		addr, err := caddy.ParseNetworkAddress(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("%w: '%v': %v", ErrInvalidUpstream, upstream, err)
+		}
+		if !addr.IsUnixNetwork() && addr.StartPort == 0 {
+			return nil, fmt.Errorf("%w: '%v': missing port", ErrInvalidUpstream, upstream)
+		}
+		if addr.PortRangeSize() > 1 {
+			return nil, fmt.Errorf("%w: '%v': port ranges can't be dialed", ErrInvalidUpstream, upstream)
+		}
+		handler.Upstreams = append(handler.Upstreams, &reverseproxy.Upstream{Dial: upstream})
+	}
+	for _, opt := range opts {
+		opt(&handler)
+	}
+	if len(handler.Upstreams) == 0 && handler.DynamicUpstreamsRaw == nil {
+		return nil, fmt.Errorf("%w: no upstreams", ErrInvalidUpstream)
+	}
+	route := caddyhttp.Route{
+		HandlersRaw: []json.RawMessage{
+			caddyconfig.JSONModuleObject(handler, "handler", "reverse_proxy", nil),
+		},
+		MatcherSetsRaw: []caddy.ModuleMap{
+			{
+				"host": caddyconfig.JSON(caddyhttp.MatchHost(matchHosts), nil),
+				"path": caddyconfig.JSON(caddyhttp.MatchPath{pathMatch}, nil),
+			},
+		},
+	}
+	return &route, nil
+}
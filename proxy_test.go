@@ -0,0 +1,160 @@
+package caddycfg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancedRouteConf(t *testing.T) {
+	route, err := LoadBalancedRouteConf(
+		[]string{"10.0.0.1:8080", "app.internal:8080", "unix//run/app.sock"},
+		[]string{"example.com"}, "/*",
+		SelectCookie("srv", "s3cret"), TryDuration(5*time.Second), TryInterval(100*time.Millisecond), Retries(2),
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{
+		"match": [{"host": ["example.com"], "path": ["/*"]}],
+		"handle": [{
+			"handler": "reverse_proxy",
+			"transport": {"protocol": "http"},
+			"upstreams": [{"dial": "10.0.0.1:8080"}, {"dial": "app.internal:8080"}, {"dial": "unix//run/app.sock"}],
+			"load_balancing": {
+				"selection_policy": {"policy": "cookie", "name": "srv", "secret": "s3cret"},
+				"retries": 2,
+				"try_duration": 5000000000,
+				"try_interval": 100000000
+			}
+		}]
+	}`
+	if !EqualJSON(b, []byte(want)) {
+		t.Errorf("Unexpected route %s", b)
+	}
+
+	for _, policy := range []struct {
+		opt  ProxyOption
+		want string
+	}{
+		{SelectRoundRobin(), `{"policy":"round_robin"}`},
+		{SelectLeastConn(), `{"policy":"least_conn"}`},
+		{SelectIPHash(), `{"policy":"ip_hash"}`},
+		{SelectHeader("X-Tenant"), `{"policy":"header","field":"X-Tenant"}`},
+	} {
+		route, err := LoadBalancedRouteConf([]string{"localhost:8080"}, []string{"example.com"}, "/*", policy.opt)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var handler struct {
+			LoadBalancing struct {
+				SelectionPolicy json.RawMessage `json:"selection_policy"`
+			} `json:"load_balancing"`
+		}
+		if err := json.Unmarshal(route.HandlersRaw[0], &handler); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !EqualJSON(handler.LoadBalancing.SelectionPolicy, []byte(policy.want)) {
+			t.Errorf("Expected selection policy %v, got %s", policy.want, handler.LoadBalancing.SelectionPolicy)
+		}
+	}
+
+	route, err = LoadBalancedRouteConf(nil, []string{"example.com"}, "/*", SRVUpstreams("http", "tcp", "app.internal", time.Minute))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var handler struct {
+		Upstreams        json.RawMessage `json:"upstreams"`
+		DynamicUpstreams json.RawMessage `json:"dynamic_upstreams"`
+	}
+	if err := json.Unmarshal(route.HandlersRaw[0], &handler); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if handler.Upstreams != nil || !EqualJSON(handler.DynamicUpstreams, []byte(`{"source":"srv","service":"http","proto":"tcp","name":"app.internal","refresh":60000000000}`)) {
+		t.Errorf("Unexpected upstreams %s and dynamic upstreams %s", handler.Upstreams, handler.DynamicUpstreams)
+	}
+	if route, err = LoadBalancedRouteConf(nil, nil, "/*", AUpstreams("app.internal", "8080", 0)); err != nil {
+		t.Errorf("%v", err)
+	} else if err := json.Unmarshal(route.HandlersRaw[0], &handler); err != nil || !EqualJSON(handler.DynamicUpstreams, []byte(`{"source":"a","name":"app.internal","port":"8080"}`)) {
+		t.Errorf("Unexpected dynamic upstreams %s, %v", handler.DynamicUpstreams, err)
+	}
+
+	for _, upstreams := range [][]string{nil, {"localhost"}, {"localhost:8080-8090"}, {"localhost:http"}} {
+		if _, err := LoadBalancedRouteConf(upstreams, []string{"example.com"}, "/*"); !errors.Is(err, ErrInvalidUpstream) {
+			t.Errorf("Expected ErrInvalidUpstream for %q, got %v", upstreams, err)
+		}
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	active := ActiveHealthCheck{
+		URI:          "/health?full=1",
+		Port:         9090,
+		Interval:     10 * time.Second,
+		Timeout:      2 * time.Second,
+		ExpectStatus: 200,
+		ExpectBody:   "^ok$",
+		Headers:      http.Header{"Host": {"internal"}},
+	}
+	passive := PassiveHealthCheck{
+		FailDuration:     30 * time.Second,
+		MaxFails:         3,
+		UnhealthyStatus:  []int{502, 503},
+		UnhealthyLatency: time.Second,
+	}
+	route := ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*", ActiveHealthChecks(active), PassiveHealthChecks(passive))
+	var handler struct {
+		HealthChecks json.RawMessage `json:"health_checks"`
+	}
+	if err := json.Unmarshal(route.HandlersRaw[0], &handler); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `{
+		"active": {"uri": "/health?full=1", "port": 9090, "headers": {"Host": ["internal"]}, "interval": 10000000000, "timeout": 2000000000, "expect_status": 200, "expect_body": "^ok$"},
+		"passive": {"fail_duration": 30000000000, "max_fails": 3, "unhealthy_status": [502, 503], "unhealthy_latency": 1000000000}
+	}`
+	if !EqualJSON(handler.HealthChecks, []byte(want)) {
+		t.Errorf("Unexpected health checks %s", handler.HealthChecks)
+	}
+
+	// Changed health checks make AddRoute update the route.
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	var reported []string
+	report := ReportChanges(func(changes []Change) {
+		for _, c := range changes {
+			reported = append(reported, c.String())
+		}
+	})
+	reordered := passive
+	reordered.UnhealthyStatus = []int{503, 502}
+	changed := passive
+	changed.UnhealthyStatus = []int{500, 502, 503}
+	steps := []struct {
+		opts []ProxyOption
+		want []string
+	}{
+		{nil, nil},
+		{[]ProxyOption{ActiveHealthChecks(active)}, []string{`+ /handle/0/health_checks: {"active":{"expect_body":"^ok$","expect_status":200,"headers":{"Host":["internal"]},"interval":10000000000,"port":9090,"timeout":2000000000,"uri":"/health?full=1"}}`}},
+		{[]ProxyOption{ActiveHealthChecks(active), PassiveHealthChecks(passive)}, []string{`+ /handle/0/health_checks/passive: {"fail_duration":30000000000,"max_fails":3,"unhealthy_latency":1000000000,"unhealthy_status":[502,503]}`}},
+		{[]ProxyOption{ActiveHealthChecks(active), PassiveHealthChecks(passive)}, nil},
+		{[]ProxyOption{ActiveHealthChecks(active), PassiveHealthChecks(reordered)}, nil},
+		{[]ProxyOption{ActiveHealthChecks(active), PassiveHealthChecks(changed)}, []string{`~ /handle/0/health_checks/passive/unhealthy_status: [502,503] -> [500,502,503]`}},
+	}
+	for i, s := range steps {
+		reported = nil
+		if err := caddyCfg.AddRoute("myserver", "example.com", ReverseProxyCaddyRouteConf(8080, []string{"example.com"}, "/*", s.opts...), report); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !reflect.DeepEqual(reported, s.want) {
+			t.Errorf("Step %d: want %q, got %q", i, s.want, reported)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// DesiredRoute is a route Reconciler keeps in Caddy's configuration under "@id" ID.
+type DesiredRoute struct {
+	ID    string
+	Route *caddyhttp.Route
+}
+
+// RouteAction is what Reconciler does to a route.
+type RouteAction int
+
+const (
+	// RouteAdded is a route that wasn't in the server's routes.
+	RouteAdded RouteAction = iota + 1
+	// RouteUpdated is a route whose configuration has changed. It's replaced in place.
+	RouteUpdated
+	// RouteMoved is a route that is out of the desired order.
+	RouteMoved
+	// RouteDeleted is a route that is not desired anymore.
+	RouteDeleted
+)
+
+func (a RouteAction) String() string {
+	switch a {
+	case RouteAdded:
+		return "add"
+	case RouteUpdated:
+		return "update"
+	case RouteMoved:
+		return "move"
+	case RouteDeleted:
+		return "delete"
+	}
+	return fmt.Sprintf("RouteAction(%d)", int(a))
+}
+
+// RouteChange is a single change to a route of a server.
+type RouteChange struct {
+	Action    RouteAction
+	ServerKey string
+	RouteID   string
+	// From is the index of the route in the server's routes before the change, or -1 for RouteAdded.
+	From int
+	// To is the index of the route in the server's routes after the change, or -1 for RouteDeleted.
+	To int
+}
+
+func (c RouteChange) String() string {
+	switch c.Action {
+	case RouteAdded:
+		return fmt.Sprintf("add route '%v' to server '%v' at %d", c.RouteID, c.ServerKey, c.To)
+	case RouteDeleted:
+		return fmt.Sprintf("delete route '%v' from server '%v' at %d", c.RouteID, c.ServerKey, c.From)
+	case RouteMoved:
+		return fmt.Sprintf("move route '%v' in server '%v' from %d to %d", c.RouteID, c.ServerKey, c.From, c.To)
+	}
+	return fmt.Sprintf("%v route '%v' in server '%v' at %d", c.Action, c.RouteID, c.ServerKey, c.To)
+}
+
+// Plan lists the changes Reconciler makes to bring routes to the desired state:
+// deletions first, and then additions, updates and moves in the order of the resulting routes.
+type Plan []RouteChange
+
+// String renders the plan one change per line.
+func (p Plan) String() string {
+	lines := make([]string, len(p))
+	for i, c := range p {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ErrDuplicateRouteID is returned by Reconciler when desired routes of a server share an "@id".
+var ErrDuplicateRouteID = errors.New("duplicate route id")
+
+// ErrRouteOwned is returned by Reconciler with ReconcileOwner when a desired route has the "@id"
+// of a route it doesn't own.
+var ErrRouteOwned = errors.New("route owned by another owner")
+
+// Reconciler brings the routes of Caddy's servers to a desired state, adding, updating, moving
+// and deleting only the routes that need it. Unlike calling AddRoute periodically, it also removes
+// routes that are not desired anymore.
+//
+// Reconciler manages the routes that have an "@id". Routes without one keep their positions and are never touched.
+// With ReconcileOwner, it only manages the routes of the owner.
+type Reconciler struct {
+	caddyCfg *CaddyCfg
+	owner    string
+}
+
+// ReconcilerOption configures Reconciler created with NewReconciler.
+type ReconcilerOption func(*Reconciler)
+
+// ReconcileOwner makes Reconciler mark the routes it writes as owned by owner, the way Owner does for AddRoute,
+// and leave the routes it doesn't own alone, so that several apps can reconcile their own routes of the same server.
+// Desiring a route with the "@id" of a route it doesn't own, including one without an owner, fails with ErrRouteOwned.
+func ReconcileOwner(owner string) ReconcilerOption {
+	return func(r *Reconciler) {
+		r.owner = owner
+	}
+}
+
+// NewReconciler returns a Reconciler making its changes with caddyCfg.
+func NewReconciler(caddyCfg *CaddyCfg, opts ...ReconcilerOption) *Reconciler {
+	r := &Reconciler{caddyCfg: caddyCfg}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Plan returns the changes Reconcile would make for desired without making them.
+func (r *Reconciler) Plan(ctx context.Context, desired map[string][]DesiredRoute) (Plan, error) {
+	return r.reconcile(ctx, desired, true)
+}
+
+// Reconcile makes the routes of every server in desired, which maps server keys to their routes,
+// be exactly the desired routes in the given order, and returns the changes it has made.
+//
+// Desired routes take the places of the routes with "@id" in the order given, and those that don't fit
+// get appended. Routes with "@id" that are not desired get deleted, so a server mapped to no routes
+// is left with routes without "@id" only. With ReconcileOwner, only the routes of the owner get deleted,
+// and routes of others are kept in place like the ones without "@id", unless a desired route has the "@id"
+// of one of them, which fails with ErrRouteOwned. Servers not in desired are not touched.
+//
+// Caddy reloads its whole configuration on every change, so all the changes to a server are made
+// with a single write of its routes, guarded by their ETag unless IfMatch is in effect. If the routes change
+// concurrently, the plan is made anew. A server with nothing to change is not written to at all.
+//
+// In case of a failure, the servers reconciled before it stay reconciled, and their changes are returned
+// along with the error.
+func (r *Reconciler) Reconcile(ctx context.Context, desired map[string][]DesiredRoute) (Plan, error) {
+	return r.reconcile(ctx, desired, false)
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, desired map[string][]DesiredRoute, dryRun bool) (Plan, error) {
+	serverKeys := make([]string, 0, len(desired))
+	for serverKey := range desired {
+		serverKeys = append(serverKeys, serverKey)
+	}
+	sort.Strings(serverKeys)
+
+	var plan Plan
+	for _, serverKey := range serverKeys {
+		p, err := r.reconcileServer(ctx, serverKey, desired[serverKey], dryRun)
+		if err != nil {
+			return plan, fmt.Errorf("reconciling server '%v': %w", serverKey, err)
+		}
+		plan = append(plan, p...)
+	}
+	return plan, nil
+}
+
+func (r *Reconciler) reconcileServer(ctx context.Context, serverKey string, desired []DesiredRoute, dryRun bool) (Plan, error) {
+	routes := make([]desiredRoute, len(desired))
+	seen := map[string]bool{}
+	for i, d := range desired {
+		if seen[d.ID] {
+			return nil, fmt.Errorf("%w: '%v'", ErrDuplicateRouteID, d.ID)
+		}
+		seen[d.ID] = true
+		cfg, err := routeJSON(d.ID, d.Route, routeMeta{Owner: r.owner})
+		if err != nil {
+			return nil, err
+		}
+		routes[i] = desiredRoute{id: d.ID, cfg: json.RawMessage(cfg)}
+	}
+
+	configURL := r.caddyCfg.pathURL("config", "apps", "http", "servers", serverKey, "routes")
+	var plan Plan
+	apply := func(current json.RawMessage) (json.RawMessage, error) {
+		var live []json.RawMessage
+		if err := json.Unmarshal(current, &live); err != nil {
+			return nil, err
+		}
+		next, p, err := planRoutes(serverKey, live, routes, r.owner)
+		if err != nil {
+			return nil, err
+		}
+		plan = p
+		if len(plan) == 0 || dryRun {
+			return nil, ErrNoChange
+		}
+		return json.Marshal(next)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.caddyCfg.modify(ctx, configURL, apply)
+		if errors.Is(err, ErrConflict) && r.caddyCfg.ifMatch == "" && attempt < maxConflictRetries {
+			continue
+		}
+		break
+	}
+	if err != nil && !errors.Is(err, ErrNoChange) {
+		return nil, err
+	}
+	return plan, nil
+}
+
+type desiredRoute struct {
+	id  string
+	cfg json.RawMessage
+}
+
+// planRoutes returns the routes of serverKey changed from live to desired along with the changes made.
+// Unless owner is empty, only the routes of owner that are not desired get deleted, and desired routes
+// taken by others are an ErrRouteOwned.
+func planRoutes(serverKey string, live []json.RawMessage, desired []desiredRoute, owner string) ([]json.RawMessage, Plan, error) {
+	wanted := make(map[string]bool, len(desired))
+	for _, d := range desired {
+		wanted[d.id] = true
+	}
+	liveIndex := map[string]int{}
+
+	var plan Plan
+	var next []json.RawMessage
+	var slots []int // desired routes go to these indices of next in order
+	for i, r := range live {
+		var f IDField
+		_ = json.Unmarshal(r, &f)
+		foreign := owner != "" && routeMetaOf(r).Owner != owner
+		switch {
+		case f.Id == "":
+			next = append(next, r)
+		case wanted[f.Id] && foreign:
+			return nil, nil, fmt.Errorf("%w: '%v' is owned by '%v'", ErrRouteOwned, f.Id, routeMetaOf(r).Owner)
+		case !wanted[f.Id] && foreign:
+			next = append(next, r)
+		case !wanted[f.Id]:
+			plan = append(plan, RouteChange{Action: RouteDeleted, ServerKey: serverKey, RouteID: f.Id, From: i, To: -1})
+		default:
+			liveIndex[f.Id] = i
+			slots = append(slots, len(next))
+			next = append(next, nil)
+		}
+	}
+	for len(slots) < len(desired) {
+		slots = append(slots, len(next))
+		next = append(next, nil)
+	}
+
+	var changes Plan
+	var kept, keptAt []int // live and new indices of the routes that stay
+	for d, to := range slots {
+		id := desired[d].id
+		from, ok := liveIndex[id]
+		if !ok {
+			next[to] = desired[d].cfg
+			changes = append(changes, RouteChange{Action: RouteAdded, ServerKey: serverKey, RouteID: id, From: -1, To: to})
+			continue
+		}
+		// The priority and lease set with AddRoute outlive the rewrite.
+		cfg := desired[d].cfg
+		if liveMeta := routeMetaOf(live[from]); liveMeta.Priority != nil || liveMeta.Expires != nil {
+			meta := routeMetaOf(cfg)
+			meta.Priority, meta.Expires = liveMeta.Priority, liveMeta.Expires
+			withMeta, err := withRouteMeta(string(cfg), meta)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg = json.RawMessage(withMeta)
+		}
+		if sameRoute(string(cfg), string(live[from])) {
+			// Keep Caddy's own rendering of what hasn't changed.
+			next[to] = live[from]
+		} else {
+			next[to] = cfg
+			changes = append(changes, RouteChange{Action: RouteUpdated, ServerKey: serverKey, RouteID: id, From: from, To: to})
+		}
+		kept = append(kept, from)
+		keptAt = append(keptAt, to)
+	}
+
+	// Routes out of the longest run kept in their original order have moved.
+	inOrder := longestIncreasing(kept)
+	for k, from := range kept {
+		if inOrder[k] {
+			continue
+		}
+		var f IDField
+		_ = json.Unmarshal(live[from], &f)
+		changes = append(changes, RouteChange{Action: RouteMoved, ServerKey: serverKey, RouteID: f.Id, From: from, To: keptAt[k]})
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].To < changes[j].To
+	})
+	return next, append(plan, changes...), nil
+}
+
+// longestIncreasing marks the members of the longest increasing subsequence of s.
+func longestIncreasing(s []int) []bool {
+	marked := make([]bool, len(s))
+	var tails []int // indices into s of the smallest tails of increasing subsequences of each length
+	prev := make([]int, len(s))
+	for i, v := range s {
+		n := sort.Search(len(tails), func(k int) bool {
+			return s[tails[k]] >= v
+		})
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			marked[i] = true
+		}
+	}
+	return marked
+}
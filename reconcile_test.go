@@ -0,0 +1,144 @@
+package caddycfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconciler(t *testing.T) {
+	admin := newFakeAdmin(t, `{"apps":{"http":{"servers":{"myserver":{"routes":[
+		{"handle":[{"handler":"static_response","body":"unmanaged"}]},
+		{"@id":"a","handle":[{"handler":"static_response","body":"a"}]}
+	]}}}}}`)
+	caddyCfg := NewCaddyCfg(admin.URL)
+	reconciler := NewReconciler(caddyCfg)
+	ctx := context.Background()
+	route := func(id string, port int) DesiredRoute {
+		return DesiredRoute{ID: id, Route: ReverseProxyCaddyRouteConf(port, []string{id + ".example.com"}, "/*")}
+	}
+
+	desired := map[string][]DesiredRoute{"myserver": {route("b", 8081), route("c", 8082), route("d", 8083)}}
+	plan, err := reconciler.Plan(ctx, desired)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `delete route 'a' from server 'myserver' at 1
+add route 'b' to server 'myserver' at 1
+add route 'c' to server 'myserver' at 2
+add route 'd' to server 'myserver' at 3`
+	if plan.String() != want {
+		t.Errorf("Plan error, want:\n%v\ngot:\n%v", want, plan)
+	}
+	if served := admin.served(); len(served) != 1 {
+		t.Errorf("Plan must only read, got %v", served)
+	}
+
+	steps := []struct {
+		desired []DesiredRoute
+		want    string
+		ids     []string
+	}{
+		{
+			[]DesiredRoute{route("b", 8081), route("c", 8082), route("d", 8083)},
+			want,
+			[]string{"", "b", "c", "d"},
+		},
+		{
+			[]DesiredRoute{route("b", 8081), route("c", 8082), route("d", 8083)},
+			``,
+			[]string{"", "b", "c", "d"},
+		},
+		{
+			[]DesiredRoute{route("d", 8083), route("b", 8081), route("c", 9000), route("e", 8084)},
+			`move route 'd' in server 'myserver' from 3 to 1
+update route 'c' in server 'myserver' at 3
+add route 'e' to server 'myserver' at 4`,
+			[]string{"", "d", "b", "c", "e"},
+		},
+		{
+			nil,
+			`delete route 'd' from server 'myserver' at 1
+delete route 'b' from server 'myserver' at 2
+delete route 'c' from server 'myserver' at 3
+delete route 'e' from server 'myserver' at 4`,
+			[]string{""},
+		},
+	}
+	for i, s := range steps {
+		admin.served()
+		plan, err := reconciler.Reconcile(ctx, map[string][]DesiredRoute{"myserver": s.desired})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if plan.String() != s.want {
+			t.Errorf("Step %d plan error, want:\n%v\ngot:\n%v", i, s.want, plan)
+		}
+		served := admin.served()
+		if s.want == "" && len(served) != 1 || s.want != "" && len(served) != 2 {
+			t.Errorf("Step %d unexpected requests %v", i, served)
+		}
+		if got := routeIDs(t, admin, "myserver"); !reflect.DeepEqual(got, s.ids) {
+			t.Errorf("Step %d want routes %v, got %v", i, s.ids, got)
+		}
+	}
+	if !strings.Contains(admin.config(), `"body":"unmanaged"`) {
+		t.Errorf("Route without @id is gone: %v", admin.config())
+	}
+
+	_, err = reconciler.Reconcile(ctx, map[string][]DesiredRoute{"myserver": {route("a", 8080), route("a", 8081)}})
+	if !errors.Is(err, ErrDuplicateRouteID) {
+		t.Errorf("Expected ErrDuplicateRouteID, got %v", err)
+	}
+}
+
+func TestReconciler_KeepsMeta(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx := context.Background()
+	if err := caddyCfg.AddRoute("myserver", "a", ReverseProxyCaddyRouteConf(8080, []string{"a"}, "/*"), Priority(5), Lease(time.Hour)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var served json.RawMessage
+	if err := caddyCfg.Get(ctx, &served, "apps", "http", "servers", "myserver", "routes", "0"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	meta := routeMetaOf(served)
+
+	// The priority and lease of a route outlive its update.
+	reconciler := NewReconciler(caddyCfg)
+	desired := map[string][]DesiredRoute{"myserver": {{ID: "a", Route: ReverseProxyCaddyRouteConf(9000, []string{"a"}, "/*")}}}
+	if plan, err := reconciler.Reconcile(ctx, desired); err != nil || plan.String() != `update route 'a' in server 'myserver' at 0` {
+		t.Fatalf("Unexpected plan %v, %v", plan, err)
+	}
+	if plan, err := reconciler.Plan(ctx, desired); err != nil || len(plan) != 0 {
+		t.Errorf("Expected no changes, got %v, %v", plan, err)
+	}
+	if err := caddyCfg.Get(ctx, &served, "apps", "http", "servers", "myserver", "routes", "0"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := routeMetaOf(served); !reflect.DeepEqual(got, meta) || got.Priority == nil || got.Expires == nil {
+		t.Errorf("Want metadata %+v, got %+v", meta, got)
+	}
+}
+
+func TestLongestIncreasing(t *testing.T) {
+	tests := []struct {
+		s    []int
+		want []bool
+	}{
+		{nil, []bool{}},
+		{[]int{0, 1, 2}, []bool{true, true, true}},
+		{[]int{3, 0, 1, 2}, []bool{false, true, true, true}},
+		{[]int{1, 2, 0}, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		if got := longestIncreasing(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("longestIncreasing(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package caddycfg
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RefreshOption tweaks Refresh.
+type RefreshOption func(*refreshOptions)
+
+type refreshOptions struct {
+	trigger        <-chan struct{}
+	backoffInitial time.Duration
+	backoffMax     time.Duration
+	jitter         float64
+	onSuccess      func()
+	onFailure      func(err error, failures int, retryIn time.Duration)
+}
+
+// RefreshTrigger makes Refresh call refresh as soon as something is received from trigger,
+// without waiting for the interval to pass. A closed trigger is ignored.
+func RefreshTrigger(trigger <-chan struct{}) RefreshOption {
+	return func(o *refreshOptions) {
+		o.trigger = trigger
+	}
+}
+
+// RefreshBackoff makes Refresh wait initial after the first failure in a row and then twice as long
+// after every consecutive failure, but never longer than max. Defaults are the interval of Refresh
+// and a minute or the interval, if it's longer.
+func RefreshBackoff(initial time.Duration, max time.Duration) RefreshOption {
+	return func(o *refreshOptions) {
+		o.backoffInitial = initial
+		o.backoffMax = max
+	}
+}
+
+// RefreshJitter randomizes every wait of Refresh by up to fraction of it in either direction,
+// so that several apps started together don't refresh in lockstep. Default is 0.1, zero turns it off.
+// Fractions are capped at maxJitter, so that waits never shrink to nothing; negative ones turn it off.
+func RefreshJitter(fraction float64) RefreshOption {
+	return func(o *refreshOptions) {
+		switch {
+		case !(fraction > 0): // NaN included
+			o.jitter = 0
+		case fraction > maxJitter:
+			o.jitter = maxJitter
+		default:
+			o.jitter = fraction
+		}
+	}
+}
+
+// maxJitter is the largest fraction RefreshJitter takes. Waits are at least a tenth of what they would be without jitter.
+const maxJitter = 0.9
+
+// OnRefreshSuccess makes Refresh call onSuccess after every successful refresh.
+func OnRefreshSuccess(onSuccess func()) RefreshOption {
+	return func(o *refreshOptions) {
+		o.onSuccess = onSuccess
+	}
+}
+
+// OnRefreshFailure makes Refresh call onFailure after every failed refresh with the error,
+// the number of failures in a row so far and the time until the next attempt.
+func OnRefreshFailure(onFailure func(err error, failures int, retryIn time.Duration)) RefreshOption {
+	return func(o *refreshOptions) {
+		o.onFailure = onFailure
+	}
+}
+
+// Refresh calls refresh first immediately and then after interval following every success,
+// until ctx is done, and returns ctx.Err(). It replaces Refresher:
+//
+//	go caddycfg.Refresh(ctx, time.Second*4, func(ctx context.Context) error {
+//		return instance.AddRouteContext(ctx, serverKey, routeId, route)
+//	}, caddycfg.OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+//		log.Printf("error changing Caddy configuration: %v, retrying in %v", err, retryIn)
+//	}))
+//
+// Failures are retried with exponential backoff, so that Caddy being down isn't hammered at full rate,
+// see RefreshBackoff. All the waits are randomized, see RefreshJitter.
+//
+// interval and the waits set with RefreshBackoff are at least a millisecond, even if they are given as zero or less.
+//
+// refresh receives ctx, so a refresh in progress gets canceled along with it.
+func Refresh(ctx context.Context, interval time.Duration, refresh func(ctx context.Context) error, opts ...RefreshOption) error {
+	o := refreshOptions{
+		backoffInitial: interval,
+		backoffMax:     time.Minute,
+		jitter:         0.1,
+	}
+	if interval > o.backoffMax {
+		o.backoffMax = interval
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Waiting for nothing would make refresh hammer Caddy.
+	interval = atLeast(interval, minRefreshWait)
+	o.backoffInitial = atLeast(o.backoffInitial, minRefreshWait)
+	o.backoffMax = atLeast(o.backoffMax, minRefreshWait)
+	// Seeded explicitly, as the global source is the same in every process before Go 1.20.
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	jittered := func(d time.Duration) time.Duration {
+		return d + time.Duration((rnd.Float64()*2-1)*o.jitter*float64(d))
+	}
+
+	trigger := o.trigger
+	var wait time.Duration
+	failures := 0
+	for {
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			case _, ok := <-trigger:
+				timer.Stop()
+				if !ok {
+					trigger = nil
+					continue
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := refresh(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			failures = 0
+			wait = jittered(interval)
+			if o.onSuccess != nil {
+				o.onSuccess()
+			}
+			continue
+		}
+		failures++
+		wait = o.backoffInitial
+		for i := 1; i < failures && wait < o.backoffMax; i++ {
+			wait *= 2
+		}
+		if wait > o.backoffMax {
+			wait = o.backoffMax
+		}
+		wait = jittered(wait)
+		if o.onFailure != nil {
+			o.onFailure(err, failures, wait)
+		}
+	}
+}
+
+// minRefreshWait is the shortest wait of Refresh.
+const minRefreshWait = time.Millisecond
+
+func atLeast(d, min time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	return d
+}
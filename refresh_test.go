@@ -0,0 +1,178 @@
+package caddycfg
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errDown := errors.New("down")
+
+	// Fails 4 times, succeeds, fails again and gets canceled.
+	calls := 0
+	var retries []time.Duration
+	successes := 0
+	err := Refresh(ctx, time.Millisecond, func(ctx context.Context) error {
+		calls++
+		switch {
+		case calls == 5:
+			return nil
+		case calls == 7:
+			cancel()
+		}
+		return errDown
+	},
+		RefreshJitter(0),
+		RefreshBackoff(time.Millisecond, 5*time.Millisecond),
+		OnRefreshSuccess(func() {
+			successes++
+		}),
+		OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+			if err != errDown {
+				t.Errorf("Unexpected error %v", err)
+			}
+			retries = append(retries, retryIn)
+		}),
+	)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond, time.Millisecond}
+	if !reflect.DeepEqual(retries, want) {
+		t.Errorf("Want retries %v, got %v", want, retries)
+	}
+	if successes != 1 || calls != 7 {
+		t.Errorf("Unexpected %d successes out of %d calls", successes, calls)
+	}
+}
+
+func TestRefresh_Trigger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	trigger := make(chan struct{})
+	calls := make(chan struct{})
+	go func() {
+		_ = Refresh(ctx, time.Hour, func(ctx context.Context) error {
+			calls <- struct{}{}
+			return nil
+		}, RefreshTrigger(trigger))
+	}()
+
+	<-calls // immediately
+	trigger <- struct{}{}
+	select {
+	case <-calls:
+	case <-ctx.Done():
+		t.Fatalf("Trigger didn't refresh")
+	}
+}
+
+func TestRefresh_Jitter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var retries []time.Duration
+	_ = Refresh(ctx, time.Millisecond, func(ctx context.Context) error {
+		if len(retries) == 20 {
+			cancel()
+		}
+		return errors.New("down")
+	}, RefreshBackoff(time.Millisecond, time.Millisecond), RefreshJitter(0.5), OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+		retries = append(retries, retryIn)
+	}))
+	varied := false
+	for _, r := range retries {
+		if r < time.Millisecond/2 || r > time.Millisecond*3/2 {
+			t.Errorf("Retry %v is out of jitter bounds", r)
+		}
+		varied = varied || r != retries[0]
+	}
+	if !varied {
+		t.Errorf("Retries are not jittered: %v", retries)
+	}
+}
+
+func TestRefreshJitter(t *testing.T) {
+	for _, tt := range []struct {
+		fraction, want float64
+	}{
+		{0.5, 0.5},
+		{0, 0},
+		{-1, 0},
+		{math.NaN(), 0},
+		{1, maxJitter},
+		{5, maxJitter},
+		{math.Inf(1), maxJitter},
+	} {
+		var o refreshOptions
+		RefreshJitter(tt.fraction)(&o)
+		if o.jitter != tt.want {
+			t.Errorf("RefreshJitter(%v): want %v, got %v", tt.fraction, tt.want, o.jitter)
+		}
+	}
+
+	// Waits don't drop to nothing even with the jitter way too large.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var retries []time.Duration
+	_ = Refresh(ctx, 10*time.Millisecond, func(ctx context.Context) error {
+		if len(retries) == 20 {
+			cancel()
+		}
+		return errors.New("down")
+	}, RefreshBackoff(10*time.Millisecond, 10*time.Millisecond), RefreshJitter(2), OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+		retries = append(retries, retryIn)
+	}))
+	for _, r := range retries {
+		if r < 900*time.Microsecond || r > 19*time.Millisecond {
+			t.Errorf("Retry %v is out of jitter bounds", r)
+		}
+	}
+}
+
+func TestRefresh_NoInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var retries []time.Duration
+	start := time.Now()
+	err := Refresh(ctx, 0, func(ctx context.Context) error {
+		if len(retries) == 10 {
+			cancel()
+		}
+		return errors.New("down")
+	}, RefreshJitter(0), OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+		retries = append(retries, retryIn)
+	}))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	for _, r := range retries {
+		if r < minRefreshWait {
+			t.Errorf("Retry %v is shorter than %v", r, minRefreshWait)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 10*minRefreshWait {
+		t.Errorf("10 retries took just %v", elapsed)
+	}
+
+	// Negative backoffs don't make failures retried right away either.
+	retries = nil
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	_ = Refresh(ctx, -time.Second, func(ctx context.Context) error {
+		if len(retries) == 3 {
+			cancel()
+		}
+		return errors.New("down")
+	}, RefreshBackoff(-time.Second, 0), RefreshJitter(0), OnRefreshFailure(func(err error, failures int, retryIn time.Duration) {
+		retries = append(retries, retryIn)
+	}))
+	if want := []time.Duration{minRefreshWait, minRefreshWait, minRefreshWait}; !reflect.DeepEqual(retries, want) {
+		t.Errorf("Want retries %v, got %v", want, retries)
+	}
+}
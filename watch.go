@@ -0,0 +1,121 @@
+package caddycfg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// markerPath is where Watch keeps its marker: the "@id" of the configuration root.
+// Caddy ignores "@id" fields when loading the configuration, but keeps them, so the marker
+// lives exactly as long as the configuration it was put into.
+const markerPath = "@id"
+
+// WatchOption tweaks Watch.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	interval time.Duration
+	onError  func(error)
+}
+
+// WatchInterval sets how often Watch polls Caddy. Default is 250ms.
+func WatchInterval(interval time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.interval = interval
+	}
+}
+
+// OnWatchError makes Watch call onError with every failed poll and failed onReset call.
+func OnWatchError(onError func(error)) WatchOption {
+	return func(o *watchOptions) {
+		o.onError = onError
+	}
+}
+
+// Watch calls onReset first immediately and then every time Caddy's configuration gets replaced,
+// such as with Upload or "caddy reload", or Caddy comes back up with a configuration other than
+// the one it had, until ctx is done, and returns ctx.Err(). onReset is a good place to re-apply
+// the routes of the app, which is way faster than waiting for the next Refresh:
+//
+//	go instance.Watch(ctx, func(ctx context.Context) error {
+//		return instance.AddRouteContext(ctx, serverKey, routeId, route)
+//	})
+//
+// Watch tells one configuration from another by a marker it puts into the "@id" of the configuration root
+// if there's none. Polling the marker is cheap, as it's just a short string. All the watchers of the same Caddy
+// share the marker, and every watcher remembers the marker it has seen last, so they all notice a replaced
+// configuration no matter which of them puts the new marker in.
+//
+// Failed onReset calls are repeated with every poll until one succeeds. onReset may upload a whole new configuration
+// itself, which doesn't make Watch call it again.
+func (caddyCfg *CaddyCfg) Watch(ctx context.Context, onReset func(ctx context.Context) error, opts ...WatchOption) error {
+	o := watchOptions{interval: 250 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// The marker is not a part of the changes IfMatch is meant to guard.
+	c := caddyCfg.IfMatch("")
+
+	var seen string
+	pending := true
+	poll := func(ctx context.Context) error {
+		marker, err := c.marker(ctx)
+		if err != nil {
+			return err
+		}
+		if marker != seen {
+			seen, pending = marker, true
+		}
+		if pending {
+			if err := onReset(ctx); err != nil {
+				return err
+			}
+			pending = false
+			// onReset may have replaced the configuration itself, such as with Upload, taking the marker away
+			// along with the old configuration. That is not a replacement to reset after again.
+			if seen, err = c.marker(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return Refresh(ctx, o.interval, poll,
+		// Caddy coming back up has to be noticed right away, so no backoff.
+		RefreshBackoff(o.interval, o.interval),
+		OnRefreshFailure(func(err error, _ int, _ time.Duration) {
+			if o.onError != nil {
+				o.onError(err)
+			}
+		}),
+	)
+}
+
+// marker returns the marker of the current configuration, putting a new one in if there's none.
+func (caddyCfg *CaddyCfg) marker(ctx context.Context) (string, error) {
+	var marker string
+	err := caddyCfg.Get(ctx, &marker, markerPath)
+	// Caddy fails to read "@id" of an empty configuration with 400 Bad Request.
+	if err != nil && !errors.Is(err, ErrBadRequest) {
+		return "", err
+	}
+	if marker != "" {
+		return marker, nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	marker = "caddycfg-" + hex.EncodeToString(b)
+	// PUT only creates, so a marker put in concurrently by another watcher is not overwritten.
+	if err := caddyCfg.Put(ctx, marker, markerPath); err != nil {
+		var theirs string
+		if caddyCfg.Get(ctx, &theirs, markerPath) == nil && theirs != "" {
+			return theirs, nil
+		}
+		return "", err
+	}
+	return marker, nil
+}
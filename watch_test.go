@@ -0,0 +1,175 @@
+package caddycfg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// watchPolls counts the marker reads a watcher makes through its transport,
+// so that tests wait for polls instead of sleeping.
+type watchPolls struct {
+	reads int32
+	down  int32
+}
+
+func (p *watchPolls) transport() http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/"+markerPath) {
+			atomic.AddInt32(&p.reads, 1)
+		}
+		if atomic.LoadInt32(&p.down) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+}
+
+// wait waits for n more marker reads. Once a watcher has called onReset, the third read after that
+// belongs to a poll that has decided whether to call onReset again.
+func (p *watchPolls) wait(t *testing.T, n int32) {
+	t.Helper()
+	target := atomic.LoadInt32(&p.reads) + n
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&p.reads) < target {
+		if time.Now().After(deadline) {
+			t.Fatalf("Watch doesn't poll")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCaddyCfg_Watch(t *testing.T) {
+	admin := newFakeAdmin(t, BaseConfig("localhost:2019", "myserver"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two apps watching the same Caddy.
+	resets := [2]chan struct{}{make(chan struct{}, 10), make(chan struct{}, 10)}
+	polls := [2]*watchPolls{{}, {}}
+	for i, reset := range resets {
+		reset := reset
+		caddyCfg := NewCaddyCfg(admin.URL, WithTransport(polls[i].transport()))
+		go func() {
+			_ = caddyCfg.Watch(ctx, func(ctx context.Context) error {
+				reset <- struct{}{}
+				return nil
+			}, WatchInterval(5*time.Millisecond))
+		}()
+	}
+	expectNoResets := func(what string) {
+		t.Helper()
+		for i, reset := range resets {
+			polls[i].wait(t, 3)
+			select {
+			case <-reset:
+				t.Fatalf("Watcher %d: unexpected reset %v", i, what)
+			default:
+			}
+		}
+	}
+	expectResets := func(what string) {
+		t.Helper()
+		for i, reset := range resets {
+			select {
+			case <-reset:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Watcher %d: no reset %v", i, what)
+			}
+		}
+		expectNoResets(what)
+	}
+	expectResets("at start")
+
+	if err := NewCaddyCfg(admin.URL).Upload(BaseConfig("localhost:2019", "myserver")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	expectResets("after upload")
+
+	// Caddy is restarted with an empty configuration.
+	for _, p := range polls {
+		atomic.StoreInt32(&p.down, 1)
+	}
+	if err := NewCaddyCfg(admin.URL).Upload("null"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, p := range polls {
+		p.wait(t, 1)
+		atomic.StoreInt32(&p.down, 0)
+	}
+	expectResets("after restart")
+
+	// Changes other than replacement don't count.
+	if err := NewCaddyCfg(admin.URL).Put(context.Background(), map[string]any{}, "apps"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	expectNoResets("after a change")
+}
+
+func TestCaddyCfg_Watch_RetriesReset(t *testing.T) {
+	admin := newFakeAdmin(t, "")
+	caddyCfg := NewCaddyCfg(admin.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls, errs int32
+	done := make(chan struct{})
+	go func() {
+		_ = caddyCfg.Watch(ctx, func(ctx context.Context) error {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return errors.New("failed to apply")
+			}
+			close(done)
+			return nil
+		}, WatchInterval(time.Millisecond), OnWatchError(func(err error) {
+			atomic.AddInt32(&errs, 1)
+		}))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("onReset wasn't retried")
+	}
+	if n := atomic.LoadInt32(&errs); n != 2 {
+		t.Errorf("Expected 2 errors, got %d", n)
+	}
+}
+
+func TestCaddyCfg_Watch_UploadOnReset(t *testing.T) {
+	admin := newFakeAdmin(t, "")
+	var polls watchPolls
+	caddyCfg := NewCaddyCfg(admin.URL, WithTransport(polls.transport()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resets := make(chan struct{}, 10)
+	go func() {
+		_ = caddyCfg.Watch(ctx, func(ctx context.Context) error {
+			resets <- struct{}{}
+			return caddyCfg.UploadContext(ctx, BaseConfig("localhost:2019", "myserver"))
+		}, WatchInterval(time.Millisecond))
+	}()
+	select {
+	case <-resets:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No reset at start")
+	}
+	polls.wait(t, 3)
+	select {
+	case <-resets:
+		t.Errorf("Unexpected reset after the upload of onReset")
+	default:
+	}
+	var marker string
+	if err := NewCaddyCfg(admin.URL).Get(context.Background(), &marker, markerPath); err != nil || marker == "" {
+		t.Errorf("Expected the marker to be put back, got %q, %v", marker, err)
+	}
+}